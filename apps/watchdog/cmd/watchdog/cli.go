@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/tasks"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+const cliUsage = `usage: watchdog <command> [flags]
+
+commands:
+  restore-snapshot -day YYYY-MM-DD -file NAME [-project NAME] [-db NAME] [-container NAME] [-confirm TOKEN]
+`
+
+// runCLI runs one command inside the watchdog container and returns the exit code.
+func runCLI(args []string) int {
+	switch args[0] {
+	case "restore-snapshot":
+		return cliRestoreSnapshot(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+}
+
+func cliRestoreSnapshot(args []string) int {
+	var req tasks.SnapshotRestore
+	var name string
+	fs := flag.NewFlagSet("restore-snapshot", flag.ContinueOnError)
+	fs.StringVar(&name, "project", project.DefaultName, "project the snapshot belongs to, see projects.json")
+	fs.StringVar(&req.Day, "day", "", "snapshot day (YYYY-MM-DD)")
+	fs.StringVar(&req.File, "file", "", "snapshot file name, see snapshots.list_files")
+	fs.StringVar(&req.Database, "db", "", "target database (default: a new restore_<day>_<HHMM>)")
+	fs.StringVar(&req.Container, "container", "", "target container (default: the project's database container)")
+	fs.StringVar(&req.Confirm, "confirm", "", "token printed by a first run against an existing database")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if req.Day == "" || req.File == "" {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+	if _, err := project.Load(); err != nil {
+		fmt.Fprintln(os.Stderr, "projects config:", err)
+		return 1
+	}
+	p, err := project.Get(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	tg := telegram.New()
+	tg.StartWorker()
+	tg = p.Notifier(tg)
+	defer tg.Drain(5 * time.Second)
+
+	ctx = tasks.WithProgress(project.With(ctx, p), func(p tasks.Progress) {
+		fmt.Fprintf(os.Stderr, "\r%3d%% %d/%d bytes", p.Percent, p.Done, p.Total)
+	})
+	res, err := tasks.RestoreSnapshot(ctx, tg, req)
+	fmt.Fprintln(os.Stderr)
+	var confirm *tasks.ConfirmationRequired
+	if errors.As(err, &confirm) {
+		fmt.Fprintf(os.Stderr, "%s already exists; to overwrite it, repeat with -confirm %s\n", confirm.Target, confirm.Token)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "restore failed:", err)
+		return 1
+	}
+	out, _ := json.MarshalIndent(res, "", "  ")
+	fmt.Println(string(out))
+	return 0
+}
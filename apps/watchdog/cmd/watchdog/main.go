@@ -1,61 +1,163 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"slices"
 	"syscall"
 	"time"
+	_ "time/tzdata" // CRON_TZ zones must resolve on alpine without tzdata
 
-	"github.com/robfig/cron/v3"
 	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/metrics"
 	"github.com/GoldenCarrotMLP/watchdog/internal/monitor"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/schedule"
 	"github.com/GoldenCarrotMLP/watchdog/internal/tasks"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 	"github.com/GoldenCarrotMLP/watchdog/internal/worker"
 )
 
+// pitrJobs only run for projects with WAL archiving.
+var pitrJobs = map[string]bool{"base_backup": true, "archive_yesterday": true, "verify_base": true}
+
 func main() {
+	// One-off commands, e.g. docker exec supabase-watchdog ./watchdog restore-snapshot ...
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
+	// Cancelled on SIGINT/SIGTERM. Loops stop right away; running tasks get
+	// tasks.ShutdownGrace to finish their uploads.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	tg := telegram.New()
 	tg.StartWorker()
 	tg.Send("🤖 Watchdog Go-Edition online at " + time.Now().Format(time.RFC822))
 
-	api.StartRedisAPI(tg)
-	worker.StartWorkers(tg)
-	tasks.StartWALUploader(tg)
-
-	go monitor.WatchDisk(tg)
-	go monitor.WatchContainers(tg)
-	go monitor.WatchLogs(tg)
+	// Projects come from projects.json; without it only main is watched.
+	projects, err := project.Load()
+	if err != nil {
+		log.Printf("❌ [PROJECT] %v", err)
+		tg.Send(fmt.Sprintf("⚠️ Invalid projects config, watching %d project(s): %v", len(projects), err))
+	}
 
-	c := cron.New()
+	sched := schedule.New()
+	api.RegisterAction("schedules.list", func(*project.Project, api.RedisRequest) (interface{}, error) { return sched.List(), nil })
+	api.RegisterAction("snapshots.restore", func(p *project.Project, r api.RedisRequest) (interface{}, error) {
+		return tasks.RestoreSnapshot(project.With(ctx, p), p.Notifier(tg), tasks.SnapshotRestore{Day: r.Day, File: r.File, Database: r.Database, Container: r.Container, Confirm: r.Confirm})
+	})
+	api.RegisterAction("snapshots.list_sets", func(p *project.Project, r api.RedisRequest) (interface{}, error) {
+		return tasks.ListSnapshotSets(project.With(ctx, p), r.Day)
+	})
+	api.RegisterAction("snapshots.restore_objects", func(p *project.Project, r api.RedisRequest) (interface{}, error) {
+		return tasks.RestoreSnapshotObjects(project.With(ctx, p), p.Notifier(tg), tasks.SelectiveRestore{Day: r.Day, File: r.File, Objects: r.Objects, Database: r.Database, Container: r.Container, SideSchema: r.SideSchema})
+	})
 
-	// 1. Logical Backups (Standard snapshots)
-	c.AddFunc("0,30 14-23 * * 1-5", func() { tasks.RunFullBackup(tg) })
+	metrics.Start()
+	api.StartRedisAPI(ctx, tg)
+	worker.StartWorkers(ctx, tg)
 
-	// 2. Physical Base Backup (Critical for PITR) - 00:01 UTC
-	c.AddFunc("1 0 * * *", func() { tasks.RunDailyBaseBackup(tg) })
+	// Host-wide checks: disk and every running container with its logs. The
+	// postgres log rules pick up each project's database container, so this
+	// starts after the projects are loaded.
+	go monitor.WatchDisk(ctx, tg)
+	go monitor.WatchContainers(ctx, tg)
+	go monitor.WatchLogs(ctx, tg)
 
-	// 3. Archive Yesterday's WALs - 23:59 UTC
-	c.AddFunc("5 0 * * *", func() { tasks.RunArchiveYesterday(tg) })
+	// Schedules come from schedules.json, each with an explicit CRON_TZ;
+	// projects may override them in projects.json.
+	specs, err := schedule.Load()
+	if err != nil {
+		log.Printf("❌ [SCHEDULE] %v", err)
+		tg.Send(fmt.Sprintf("⚠️ Invalid schedules config, falling back to defaults: %v", err))
+	}
+	for _, p := range projects {
+		pctx, ptg := project.With(ctx, p), p.Notifier(tg)
+		if p.PITR() {
+			tasks.StartWALUploader(pctx, ptg)
+			go monitor.WatchWALArchiving(pctx, ptg)
+		}
+		if p.HasDatabase() {
+			go monitor.WatchPostgres(pctx, ptg)
+		}
 
-	c.Start()
+		jobs := map[string]func() error{
+			// 1. Logical Backups (Standard snapshots)
+			"snapshot": func() error { return tasks.RunFullBackup(pctx, ptg) },
+			// 2. Physical Base Backup (Critical for PITR)
+			"base_backup": func() error { return tasks.RunDailyBaseBackup(pctx, ptg) },
+			// 3. Archive yesterday's WALs, shortly after midnight
+			"archive_yesterday": func() error { return tasks.RunArchiveYesterday(pctx, ptg) },
+			// 4. Re-download a sample of base backups and verify them against their manifests
+			"verify_base": func() error { return tasks.VerifySampledBaseBackups(pctx, ptg) },
+			// 5. Nightly RPO report across snapshots and PITR windows
+			"recovery_report": func() error { return tasks.RunRecoveryReport(pctx, ptg) },
+			// 6. Compare the backup destinations and repair missing copies
+			"replica_parity": func() error { return tasks.RunReplicaParity(pctx, ptg) },
+		}
+		pspecs := p.Schedules
+		if pspecs == nil {
+			pspecs = specs
+		}
+		for _, j := range pspecs {
+			if pitrJobs[j.Name] && !p.PITR() || j.Name == "replica_parity" && len(p.Mirrors()) == 0 {
+				continue
+			}
+			if err := sched.Add(p.Key(j.Name), j.Spec, jobs[j.Name]); err != nil {
+				log.Printf("❌ [SCHEDULE] %s: %v", p.Key(j.Name), err)
+			}
+		}
+	}
+	sched.Start()
 
 	// --- STARTUP CHECKS ---
-	log.Println("🚀 Watchdog initialized.")
+	log.Printf("🚀 Watchdog initialized for %d project(s).", len(projects))
 
-	// A. Ensure we have a Logical Backup (Standard)
-	go tasks.RunFullBackup(tg)
+	for _, p := range projects {
+		pctx, ptg := project.With(ctx, p), p.Notifier(tg)
 
-	// B. Ensure we have a Physical Base Backup for TODAY (PITR)
-	// This ensures if you restart at 10AM, you don't wait 14 hours for a base.
-	go tasks.CheckAndRunStartupBaseBackup(tg)
+		// 0. Finish whatever the previous run was interrupted in
+		resumed := tasks.ResumeIncomplete(pctx, ptg)
 
-	// C. Check for missing archives from past days
-	go tasks.RunStartupBackfill(tg)
+		// A. Ensure we have a Logical Backup (Standard)
+		go tasks.RunFullBackup(pctx, ptg)
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+		if !p.PITR() {
+			continue
+		}
+		// B. Ensure we have a Physical Base Backup for TODAY (PITR)
+		// This ensures if you restart at 10AM, you don't wait 14 hours for a base.
+		if !slices.Contains(resumed, "base_backup") {
+			go tasks.CheckAndRunStartupBaseBackup(pctx, ptg)
+		}
+
+		// C. Check for missing archives from past days
+		go tasks.RunStartupBackfill(pctx, ptg)
+	}
+
+	<-ctx.Done()
 	log.Println("🛑 Shutdown signal received")
-}
\ No newline at end of file
+
+	// Stop scheduling, then wait for cron jobs and every other running task.
+	waitCtx, cancel := context.WithTimeout(context.Background(), tasks.ShutdownGrace+5*time.Second)
+	defer cancel()
+	select {
+	case <-sched.Stop():
+	case <-waitCtx.Done():
+	}
+	if !tasks.Wait(waitCtx) {
+		log.Println("⚠️ Grace period expired, interrupted tasks will resume on next start")
+	}
+
+	tg.Send("🛑 Watchdog shutting down at " + time.Now().Format(time.RFC822))
+	for _, p := range projects {
+		p.Notifier(tg).Drain(5 * time.Second)
+	}
+	tg.Drain(5 * time.Second)
+	log.Println("👋 Watchdog stopped")
+}
@@ -10,18 +10,23 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
 )
 
-// In-memory cache for historical metadata
+// In-memory cache for historical metadata, keyed by project and day
 var (
 	metadataCache = make(map[string]PitrMetadata)
 	cacheMutex    sync.RWMutex
 )
 
 // ListPitrDays queries Dropbox for all available recovery folders
-func ListPitrDays() ([]DayEntry, error) {
+func ListPitrDays(p *project.Project) ([]DayEntry, error) {
+	if !p.PITR() {
+		return []DayEntry{}, nil
+	}
 	cmd := exec.Command("docker", "exec", "supabase-rclone", "rclone",
-		"lsjson", "--dirs-only", "dropbox:SupabaseServerBackups_WAL/")
+		"lsjson", "--dirs-only", p.WALRemote())
 
 	out, err := cmd.Output()
 	if err != nil {
@@ -53,20 +58,24 @@ func ListPitrDays() ([]DayEntry, error) {
 }
 
 // GetContiguousWALRange logic: Cache -> Dropbox Metadata -> Live Dropbox Scan
-func GetContiguousWALRange(day string) (PitrMetadata, error) {
+func GetContiguousWALRange(p *project.Project, day string) (PitrMetadata, error) {
 	today := time.Now().Format("2006-01-02")
+	if !p.PITR() {
+		return PitrMetadata{Date: day}, fmt.Errorf("project %s has no PITR", p.Name)
+	}
+	key := p.Name + "/" + day
 
 	// 1. Check Memory Cache (Only for past days)
 	if day != today {
 		cacheMutex.RLock()
-		cached, exists := metadataCache[day]
+		cached, exists := metadataCache[key]
 		cacheMutex.RUnlock()
 		if exists {
 			return cached, nil
 		}
 	}
 
-	remoteRoot := fmt.Sprintf("dropbox:SupabaseServerBackups_WAL/%s", day)
+	remoteRoot := p.WALRemote(day)
 
 	// 2. Query Dropbox to check what files exist
 	lsCmd := exec.Command("docker", "exec", "supabase-rclone", "rclone", "lsjson", remoteRoot+"/")
@@ -102,7 +111,7 @@ func GetContiguousWALRange(day string) (PitrMetadata, error) {
 			if err := json.Unmarshal(catOut, &m); err == nil {
 				// Store in memory cache for future requests
 				cacheMutex.Lock()
-				metadataCache[day] = m
+				metadataCache[key] = m
 				cacheMutex.Unlock()
 				return m, nil
 			}
@@ -132,7 +141,7 @@ func GetContiguousWALRange(day string) (PitrMetadata, error) {
 	// Cache historical days to stop hitting Dropbox, but skip caching "Today"
 	if day != today && m.Continuous {
 		cacheMutex.Lock()
-		metadataCache[day] = m
+		metadataCache[key] = m
 		cacheMutex.Unlock()
 	}
 
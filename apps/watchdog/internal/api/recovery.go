@@ -0,0 +1,154 @@
+package api
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+)
+
+// RecoveryReportDays is how far back recovery.report looks by default (RECOVERY_REPORT_DAYS).
+var RecoveryReportDays = func() int {
+	if n, err := strconv.Atoi(config.Env("RECOVERY_REPORT_DAYS", "14")); err == nil && n > 0 {
+		return n
+	}
+	return 14
+}()
+
+// RecoveryInterval is a stretch of time we can restore to, either any point
+// inside a continuous PITR window or a single logical snapshot (Start == End).
+type RecoveryInterval struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Source string    `json:"source"` // pitr:<day> or snapshot:<file> of the earliest point
+	Points int       `json:"points"` // PITR windows and snapshots merged into this interval
+}
+
+// RecoveryGap is a stretch with no recovery point: losing data written in it
+// would cost up to Duration of work.
+type RecoveryGap struct {
+	Start    time.Time     `json:"start"`
+	End      time.Time     `json:"end"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// RecoveryReport answers "what is the worst data loss we could suffer right now?"
+type RecoveryReport struct {
+	Project     string             `json:"project"`
+	GeneratedAt time.Time          `json:"generated_at"`
+	WindowStart time.Time          `json:"window_start"`
+	Days        int                `json:"days"`
+	Intervals   []RecoveryInterval `json:"intervals"`
+	Gaps        []RecoveryGap      `json:"gaps"`
+	MaxRPO      time.Duration      `json:"max_rpo_ns"`
+	CurrentRPO  time.Duration      `json:"current_rpo_ns"` // since the newest recovery point
+	Coverage    float64            `json:"coverage"`       // share of the window inside PITR windows
+	LastPoint   time.Time          `json:"last_point"`
+	Problems    []string           `json:"problems,omitempty"`
+}
+
+// BuildRecoveryReport combines every day's PITR metadata with the logical
+// snapshots of the last days and computes recoverable intervals and gaps.
+// Projects without PITR only count their snapshots.
+func BuildRecoveryReport(p *project.Project, days int) RecoveryReport {
+	if days <= 0 {
+		days = RecoveryReportDays
+	}
+	now := time.Now().UTC()
+	rep := RecoveryReport{Project: p.Name, GeneratedAt: now, Days: days, WindowStart: now.AddDate(0, 0, -days)}
+
+	var points []RecoveryInterval
+	for i := days; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i).Format("2006-01-02")
+
+		if m, err := GetContiguousWALRange(p, day); p.PITR() && err == nil {
+			switch {
+			case m.BaseBackupTimestamp.IsZero() || m.BaseBackupTimestamp.Year() <= 2000:
+				rep.Problems = append(rep.Problems, day+": no base backup time")
+			case m.ValidUntil.Before(m.BaseBackupTimestamp):
+				rep.Problems = append(rep.Problems, day+": no WAL after base backup")
+			default:
+				points = append(points, RecoveryInterval{Start: m.BaseBackupTimestamp, End: m.ValidUntil, Source: "pitr:" + day, Points: 1})
+			}
+			if !m.Continuous {
+				rep.Problems = append(rep.Problems, day+": WAL gap at "+strings.Join(m.MissingSegments, ","))
+			}
+		}
+
+		files, _ := ListSnapshotFiles(p, day)
+		for _, f := range files {
+			if !f.Time.IsZero() {
+				points = append(points, RecoveryInterval{Start: f.Time, End: f.Time, Source: "snapshot:" + f.Filename, Points: 1})
+			}
+		}
+	}
+
+	rep.Intervals = mergeIntervals(points, rep.WindowStart, now)
+	rep.Gaps, rep.MaxRPO, rep.Coverage = findGaps(rep.Intervals, rep.WindowStart, now)
+	if n := len(rep.Intervals); n > 0 {
+		rep.LastPoint = rep.Intervals[n-1].End
+		rep.CurrentRPO = now.Sub(rep.LastPoint)
+	} else {
+		rep.CurrentRPO = now.Sub(rep.WindowStart)
+	}
+	return rep
+}
+
+// mergeIntervals clips to the window and joins overlapping intervals.
+func mergeIntervals(in []RecoveryInterval, from, to time.Time) []RecoveryInterval {
+	var clipped []RecoveryInterval
+	for _, iv := range in {
+		if iv.End.Before(from) || iv.Start.After(to) {
+			continue
+		}
+		if iv.Start.Before(from) {
+			iv.Start = from
+		}
+		if iv.End.After(to) {
+			iv.End = to
+		}
+		clipped = append(clipped, iv)
+	}
+	sort.Slice(clipped, func(i, j int) bool { return clipped[i].Start.Before(clipped[j].Start) })
+
+	var out []RecoveryInterval
+	for _, iv := range clipped {
+		if n := len(out); n > 0 && !iv.Start.After(out[n-1].End) {
+			if iv.End.After(out[n-1].End) {
+				out[n-1].End = iv.End
+			}
+			out[n-1].Points += iv.Points
+			continue
+		}
+		out = append(out, iv)
+	}
+	return out
+}
+
+// findGaps returns the holes between intervals, the largest one including
+// the exposure since the newest point, and the share of the window covered.
+func findGaps(ivs []RecoveryInterval, from, to time.Time) ([]RecoveryGap, time.Duration, float64) {
+	var gaps []RecoveryGap
+	var maxRPO, covered time.Duration
+	cursor := from
+	for _, iv := range ivs {
+		if d := iv.Start.Sub(cursor); d > 0 {
+			gaps = append(gaps, RecoveryGap{Start: cursor, End: iv.Start, Duration: d})
+			maxRPO = max(maxRPO, d)
+		}
+		covered += iv.End.Sub(iv.Start)
+		cursor = iv.End
+	}
+	if d := to.Sub(cursor); d > 0 {
+		gaps = append(gaps, RecoveryGap{Start: cursor, End: to, Duration: d})
+		maxRPO = max(maxRPO, d)
+	}
+	window := to.Sub(from)
+	if window <= 0 {
+		return gaps, maxRPO, 0
+	}
+	return gaps, maxRPO, float64(covered) / float64(window)
+}
@@ -5,28 +5,67 @@ import (
 	"encoding/json"
 	"log"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
-func StartRedisAPI(tg *telegram.Service) {
-	ctx := context.Background()
+// Handler answers one API action; the request arrives on "<action>.request"
+// and the response is published on "<action>.response". p is the project the
+// request names (main when it names none).
+type Handler func(p *project.Project, r RedisRequest) (interface{}, error)
+
+var (
+	handlersMu sync.Mutex
+	handlers   = map[string]Handler{
+		"pitr.list_days":       func(p *project.Project, _ RedisRequest) (interface{}, error) { return ListPitrDays(p) },
+		"pitr.get_window":      func(p *project.Project, r RedisRequest) (interface{}, error) { return GetContiguousWALRange(p, r.Day) },
+		"snapshots.list_days":  func(p *project.Project, _ RedisRequest) (interface{}, error) { return ListSnapshotDays(p) },
+		"snapshots.list_files": func(p *project.Project, r RedisRequest) (interface{}, error) { return ListSnapshotFiles(p, r.Day) },
+		"snapshots.toc":        func(p *project.Project, r RedisRequest) (interface{}, error) { return SnapshotTOC(p, r.Day, r.File) },
+		"snapshots.fetch":      func(p *project.Project, r RedisRequest) (interface{}, error) { return FetchSnapshot(p, r.Day, r.File, r.Mode, r.Expire) },
+		"recovery.report":      func(p *project.Project, r RedisRequest) (interface{}, error) { return BuildRecoveryReport(p, r.Days), nil },
+		"projects.list":        func(*project.Project, RedisRequest) (interface{}, error) { return project.Infos(), nil },
+		"replicas.status":      func(p *project.Project, _ RedisRequest) (interface{}, error) { return replica.LastReport(p) },
+	}
+)
+
+// readActions are served from a replica when the primary destination is down.
+var readActions = map[string]bool{
+	"pitr.list_days": true, "pitr.get_window": true, "snapshots.list_days": true,
+	"snapshots.list_files": true, "snapshots.toc": true, "snapshots.fetch": true,
+}
+
+// RegisterAction adds an action served by packages the api cannot import
+// (schedules, tasks). Call it before StartRedisAPI.
+func RegisterAction(action string, h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[action] = h
+}
+
+func StartRedisAPI(ctx context.Context, tg *telegram.Service) {
 	addr := os.Getenv("REDIS_HOST")
 	if addr == "" { addr = "redis" }
 	rdb := redis.NewClient(&redis.Options{Addr: addr + ":6379"})
 
-	channels := []string{
-		"pitr.list_days.request",
-		"pitr.get_window.request",
-		"snapshots.list_days.request",
-		"snapshots.list_files.request",
+	handlersMu.Lock()
+	var channels []string
+	for action := range handlers {
+		channels = append(channels, action+".request")
 	}
+	handlersMu.Unlock()
+	sort.Strings(channels)
 
 	go func() {
 		pubsub := rdb.Subscribe(ctx, channels...)
 		log.Printf("📡 [API] Redis Listener Online for %v", channels)
+		context.AfterFunc(ctx, func() { pubsub.Close() })
 
 		for msg := range pubsub.Channel() {
 			var req RedisRequest
@@ -35,17 +74,20 @@ func StartRedisAPI(tg *telegram.Service) {
 			}
 
 			go func(r RedisRequest, channel string) {
+				action := strings.TrimSuffix(channel, ".request")
+				handlersMu.Lock()
+				h := handlers[action]
+				handlersMu.Unlock()
+				if h == nil {
+					return
+				}
 				var data interface{}
-				var err error
-
-				if strings.Contains(channel, "pitr.list_days") {
-					data, err = ListPitrDays()
-				} else if strings.Contains(channel, "pitr.get_window") {
-					data, err = GetContiguousWALRange(r.Day)
-				} else if strings.Contains(channel, "snapshots.list_days") {
-					data, err = ListSnapshotDays()
-				} else if strings.Contains(channel, "snapshots.list_files") {
-					data, err = ListSnapshotFiles(r.Day)
+				p, err := project.Get(r.Project)
+				if err == nil && readActions[action] {
+					p = replica.Reader(ctx, p)
+				}
+				if err == nil {
+					data, err = h(p, r)
 				}
 
 				resp := RedisResponse{
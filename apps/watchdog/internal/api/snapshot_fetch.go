@@ -0,0 +1,109 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+)
+
+// Downloads land in ./backup/downloads (downloads/<project> for projects
+// other than main), seen as /app/backup here and as /backup inside the
+// rclone container, and are pruned after a day.
+const (
+	snapshotDownloadDir       = "/app/backup/downloads"
+	snapshotDownloadDirRclone = "/backup/downloads"
+	snapshotDownloadTTL       = 24 * time.Hour
+)
+
+// FetchSnapshot makes one snapshot available for download: mode "local"
+// copies it next to the watchdog and checks its hash, mode "link" asks the
+// remote for a share link valid for expire (default 1h).
+func FetchSnapshot(p *project.Project, day, file, mode, expire string) (SnapshotFetch, error) {
+	res := SnapshotFetch{Day: day, File: file, Mode: mode}
+	if res.Mode == "" {
+		res.Mode = "local"
+	}
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return res, fmt.Errorf("invalid day %q", day)
+	}
+
+	// Only names the listing returned are accepted, so file cannot escape the day folder.
+	files, err := ListSnapshotFiles(p, day)
+	if err != nil {
+		return res, fmt.Errorf("list snapshots of %s: %w", day, err)
+	}
+	var snap *SnapshotFile
+	for i := range files {
+		if files[i].Filename == file {
+			snap = &files[i]
+		}
+	}
+	if snap == nil {
+		return res, fmt.Errorf("snapshot %s/%s not found", day, file)
+	}
+	res.Size, res.Checksum = snap.Size, snap.Checksum
+	remote := p.SnapshotRemote(day, file)
+
+	switch res.Mode {
+	case "link":
+		if expire == "" {
+			expire = "1h"
+		}
+		ttl, err := time.ParseDuration(expire)
+		if err != nil || ttl <= 0 {
+			return res, fmt.Errorf("invalid expire %q", expire)
+		}
+		out, err := exec.Command("docker", "exec", "supabase-rclone", "rclone", "link", "--expire", expire, remote).CombinedOutput()
+		if err != nil {
+			return res, fmt.Errorf("rclone link: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		res.URL = strings.TrimSpace(string(out))
+		res.ExpiresAt = time.Now().Add(ttl).UTC()
+		log.Printf("🔗 [API] Share link for %s/%s valid %s", day, file, expire)
+
+	case "local":
+		dir, dirRclone := snapshotDownloadDir, snapshotDownloadDirRclone
+		if !p.IsDefault() {
+			dir, dirRclone = dir+"/"+p.Name, dirRclone+"/"+p.Name
+		}
+		pruneSnapshotDownloads(dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return res, err
+		}
+		target := dirRclone + "/" + file
+		if out, err := exec.Command("docker", "exec", "supabase-rclone", "rclone", "copyto", remote, target).CombinedOutput(); err != nil {
+			return res, fmt.Errorf("rclone copyto: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		res.Path = filepath.Join(dir, file)
+
+		if res.Checksum != "" {
+			out, err := exec.Command("docker", "exec", "supabase-rclone", "rclone", "hashsum", "dropbox", target).Output()
+			sum, _, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+			if err != nil || sum != res.Checksum {
+				os.Remove(res.Path)
+				return res, fmt.Errorf("downloaded %s does not match remote hash", file)
+			}
+		}
+		log.Printf("📥 [API] Downloaded %s/%s to %s", day, file, res.Path)
+
+	default:
+		return res, fmt.Errorf("invalid mode %q (local or link)", res.Mode)
+	}
+	return res, nil
+}
+
+// pruneSnapshotDownloads removes downloads older than snapshotDownloadTTL.
+func pruneSnapshotDownloads(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !e.IsDir() && time.Since(info.ModTime()) > snapshotDownloadTTL {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+}
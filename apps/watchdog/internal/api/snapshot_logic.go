@@ -2,16 +2,18 @@ package api
 
 import (
 	"encoding/json"
-	"fmt"
 	"os/exec"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
 )
 
-func ListSnapshotDays() ([]DayEntry, error) {
+func ListSnapshotDays(p *project.Project) ([]DayEntry, error) {
 	cmd := exec.Command("docker", "exec", "supabase-rclone", "rclone", 
-		"lsjson", "--dirs-only", "dropbox:SupabaseServerBackups")
+		"lsjson", "--dirs-only", p.SnapshotRemote())
 	
 	out, err := cmd.Output()
 	if err != nil {
@@ -50,34 +52,61 @@ func ListSnapshotDays() ([]DayEntry, error) {
 
 
 
-func ListSnapshotFiles(day string) ([]SnapshotFile, error) {
-	remotePath := fmt.Sprintf("dropbox:SupabaseServerBackups/%s", day)
-	cmd := exec.Command("docker", "exec", "supabase-rclone", "rclone", "lsf", "--format", "ps", remotePath)
+// ListSnapshotFiles lists one day's snapshots with their size, content hash
+// and the database, time and format encoded in their file names. Table of
+// contents files (<snapshot>.toc) are folded into their snapshot.
+func ListSnapshotFiles(p *project.Project, day string) ([]SnapshotFile, error) {
+	remotePath := p.SnapshotRemote(day)
+	cmd := exec.Command("docker", "exec", "supabase-rclone", "rclone",
+		"lsjson", "--files-only", "--hash", "--hash-type", "dropbox", remotePath)
 	out, err := cmd.Output()
 	if err != nil {
 		return []SnapshotFile{}, nil
 	}
 
-	var files []SnapshotFile
-	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
-	for _, line := range lines {
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			continue
-		}
-		
-		filename := parts[0]
-		timestamp := ""
-		if strings.Contains(filename, "_") {
-			tsParts := strings.Split(filename, "_")
-			timestamp = strings.TrimSuffix(tsParts[len(tsParts)-1], ".sql.gz")
+	var items []RcloneItem
+	if err := json.Unmarshal(out, &items); err != nil {
+		return []SnapshotFile{}, nil
+	}
+
+	tocs := make(map[string]bool)
+	for _, item := range items {
+		if strings.HasSuffix(item.Name, ".toc") {
+			tocs[strings.TrimSuffix(item.Name, ".toc")] = true
 		}
+	}
 
-		files = append(files, SnapshotFile{
-			Filename:  filename,
-			Size:      parts[1],
-			Timestamp: timestamp,
-		})
+	files := []SnapshotFile{}
+	for _, item := range items {
+		if strings.HasSuffix(item.Name, ".toc") || strings.HasSuffix(item.Name, ".json") {
+			continue // TOCs and snapshot set manifests
+		}
+		f := SnapshotFile{Filename: item.Name, Size: item.Size, Time: item.ModTime, Checksum: item.Hashes["dropbox"],
+			Format: SnapshotFormat(item.Name), TOC: tocs[item.Name]}
+		if m := snapshotName.FindStringSubmatch(item.Name); m != nil {
+			f.Database = m[1]
+			if t, err := time.Parse("2006-01-02 15-04", m[2]+" "+m[3]); err == nil {
+				f.Time = t
+			}
+		}
+		files = append(files, f)
 	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Time.Before(files[j].Time) })
 	return files, nil
-}
\ No newline at end of file
+}
+
+// supabase-<db>-<YYYY-MM-DD>_<HH-MM-AM|PM>.<ext>, the hour being 24h based.
+var snapshotName = regexp.MustCompile(`^supabase-(.+)-(\d{4}-\d{2}-\d{2})_(\d{2}-\d{2})-[AP]M\.(sql\.gz|dump|dir\.tar)$`)
+
+// SnapshotFormat tells the dump format from the file name: plain (.sql.gz),
+// custom (.dump) or directory (.dir.tar, a tar of pg_dump -Fd output).
+func SnapshotFormat(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".dump"):
+		return "custom"
+	case strings.HasSuffix(name, ".dir.tar"):
+		return "directory"
+	default:
+		return "plain"
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+)
+
+// Multi-word entry types first so "TABLE DATA" is not read as "TABLE".
+var tocTypes = func() []string {
+	t := []string{
+		"TABLE DATA", "TABLE", "SEQUENCE SET", "SEQUENCE OWNED BY", "SEQUENCE",
+		"FK CONSTRAINT", "CHECK CONSTRAINT", "CONSTRAINT", "INDEX ATTACH", "INDEX",
+		"MATERIALIZED VIEW DATA", "MATERIALIZED VIEW", "VIEW", "DEFAULT ACL", "DEFAULT",
+		"ROW SECURITY", "POLICY", "TRIGGER", "EVENT TRIGGER", "FUNCTION", "PROCEDURE",
+		"AGGREGATE", "TYPE", "DOMAIN", "SCHEMA", "EXTENSION", "COMMENT", "ACL",
+		"PUBLICATION TABLE", "PUBLICATION", "RULE", "LARGE OBJECT", "BLOBS",
+	}
+	sort.SliceStable(t, func(i, j int) bool { return len(t[i]) > len(t[j]) })
+	return t
+}()
+
+// ParseTOC reads `pg_restore -l` output. Lines look like
+// "215; 1259 16390 TABLE public tickets postgres"; comments are skipped.
+func ParseTOC(text string) []TOCEntry {
+	var entries []TOCEntry
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		idStr, rest, ok := strings.Cut(line, ";")
+		if !ok || strings.HasPrefix(line, ";") {
+			continue
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			continue
+		}
+		// Skip the catalog and object OIDs.
+		fields := strings.Fields(rest)
+		if len(fields) < 5 {
+			continue
+		}
+		rest = strings.Join(fields[2:], " ")
+
+		e := TOCEntry{ID: id, Line: line}
+		for _, t := range tocTypes {
+			if strings.HasPrefix(rest, t+" ") {
+				e.Type = t
+				break
+			}
+		}
+		if e.Type == "" {
+			e.Type, _, _ = strings.Cut(rest, " ")
+		}
+		names := strings.Fields(strings.TrimPrefix(rest, e.Type+" "))
+		if len(names) < 3 {
+			continue
+		}
+		e.Schema = names[0]
+		e.Owner = names[len(names)-1]
+		e.Name = strings.Join(names[1:len(names)-1], " ")
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// SnapshotTOC returns the stored table of contents of a custom or directory snapshot.
+func SnapshotTOC(p *project.Project, day, file string) ([]TOCEntry, error) {
+	if SnapshotFormat(file) == "plain" {
+		return nil, fmt.Errorf("%s is a plain SQL dump without a table of contents", file)
+	}
+	if strings.ContainsAny(file, "/\\") || strings.ContainsAny(day, "/\\") {
+		return nil, fmt.Errorf("invalid snapshot %s/%s", day, file)
+	}
+	remote := p.SnapshotRemote(day, file+".toc")
+	out, err := exec.Command("docker", "exec", "supabase-rclone", "rclone", "cat", remote).Output()
+	if err != nil {
+		return nil, fmt.Errorf("no table of contents for %s/%s: %w", day, file, err)
+	}
+	return ParseTOC(string(out)), nil
+}
@@ -7,6 +7,8 @@ type RcloneItem struct {
 	IsDir   bool      `json:"IsDir"`
 	Size    int64     `json:"Size"`
 	ModTime time.Time `json:"ModTime"`
+
+	Hashes map[string]string `json:"Hashes,omitempty"` // only with lsjson --hash
 }
 
 type PitrMetadata struct {
@@ -22,6 +24,42 @@ type PitrMetadata struct {
 	MissingSegments     []string  `json:"missing_segments"`
 	ValidUntil          time.Time `json:"valid_until"`
 	IsArchived          bool      `json:"is_archived"`
+	BackupType          string    `json:"backup_type,omitempty"` // full or incremental
+	BaseChain           []string  `json:"base_chain,omitempty"`  // days to combine, oldest (full) first
+
+	BaseVerification *BaseVerification `json:"base_verification,omitempty"`
+	WALArchive       *WALArchive       `json:"wal_archive,omitempty"`
+}
+
+// WALArchive describes a day's WAL archive as uploaded and verified.
+type WALArchive struct {
+	Name        string `json:"name"`
+	Compression string `json:"compression"` // gzip or zstd
+	Segments    int    `json:"segments"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	Verified    bool   `json:"verified"`
+}
+
+// BaseBackupInfo is stored as <day>/base_backup.json next to base.tar.gz.
+type BaseBackupInfo struct {
+	Date    string    `json:"date"`
+	Type    string    `json:"type"`             // full or incremental
+	Parent  string    `json:"parent,omitempty"` // day whose manifest this backup is incremental to
+	Chain   []string  `json:"chain"`            // full backup first, this day last
+	Started time.Time `json:"started"`
+	Size    int64     `json:"size"`
+
+	Verification *BaseVerification `json:"verification,omitempty"`
+}
+
+// BaseVerification is the latest check of a base backup against its manifest.
+type BaseVerification struct {
+	OK         bool      `json:"ok"`
+	Source     string    `json:"source"` // upload (inline) or download (sampled re-check)
+	Files      int       `json:"files"`
+	Error      string    `json:"error,omitempty"`
+	VerifiedAt time.Time `json:"verified_at"`
 }
 type DayEntry struct {
 	Date      string    `json:"date"`
@@ -29,15 +67,75 @@ type DayEntry struct {
 }
 
 type SnapshotFile struct {
-	Filename  string `json:"filename"`
-	Size      string `json:"size"`
-	Timestamp string `json:"timestamp"`
+	Filename string    `json:"filename"`
+	Database string    `json:"database,omitempty"`
+	Size     int64     `json:"size"`
+	Time     time.Time `json:"timestamp"`          // from the file name, else the remote mod time
+	Checksum string    `json:"checksum,omitempty"` // Dropbox content hash
+	Format   string    `json:"format"`             // plain, custom or directory
+	TOC      bool      `json:"toc,omitempty"`      // a <filename>.toc sits next to it
+}
+
+// SnapshotSet is the manifest of one snapshot run, stored next to its files
+// as <day>/snapshot-set_<HH-MM-PM>.json.
+type SnapshotSet struct {
+	Name             string            `json:"name"`
+	Day              string            `json:"day"`
+	Started          time.Time         `json:"started"`
+	Finished         time.Time         `json:"finished"`
+	Format           string            `json:"format"`
+	Databases        []SnapshotSetFile `json:"databases"`
+	Globals          *SnapshotSetFile  `json:"globals,omitempty"` // pg_dumpall --globals-only
+	GlobalsPasswords bool              `json:"globals_passwords"` // role password hashes included
+	Complete         bool              `json:"complete"`
+	Errors           []string          `json:"errors,omitempty"`
+}
+
+type SnapshotSetFile struct {
+	Database string `json:"database"`
+	File     string `json:"file"`
+	Format   string `json:"format"`
+	Size     int64  `json:"size"`
+	TOC      bool   `json:"toc,omitempty"`
+}
+
+// TOCEntry is one line of `pg_restore -l`.
+type TOCEntry struct {
+	ID     int    `json:"id"`
+	Type   string `json:"type"`   // TABLE, TABLE DATA, SEQUENCE, CONSTRAINT, ...
+	Schema string `json:"schema"` // "-" for schema-less objects
+	Name   string `json:"name"`
+	Owner  string `json:"owner"`
+	Line   string `json:"-"`
+}
+
+// SnapshotFetch is the result of snapshots.fetch: a local copy or a share link.
+type SnapshotFetch struct {
+	Day       string    `json:"day"`
+	File      string    `json:"file"`
+	Mode      string    `json:"mode"` // local or link
+	Path      string    `json:"path,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum,omitempty"`
 }
 
 type RedisRequest struct {
 	CorrelationID string `json:"correlation_id"`
 	Action        string `json:"action"`
+	Project       string `json:"project,omitempty"` // project name, default main
 	Day           string `json:"day,omitempty"`
+	Days          int    `json:"days,omitempty"` // recovery.report window, defaults to RECOVERY_REPORT_DAYS
+	File          string `json:"file,omitempty"`
+	Mode          string `json:"mode,omitempty"`      // snapshots.fetch: local (default) or link
+	Expire        string `json:"expire,omitempty"`    // snapshots.fetch link lifetime, e.g. "1h"
+	Database      string `json:"database,omitempty"`  // snapshots.restore target, default a new restore_<day>_<HHMM>
+	Container     string `json:"container,omitempty"` // snapshots.restore target container, default supabase-db
+	Confirm       string `json:"confirm,omitempty"`   // snapshots.restore token for existing production databases
+
+	Objects    []string `json:"objects,omitempty"`     // snapshots.restore_objects: "schema" or "schema.table"
+	SideSchema string   `json:"side_schema,omitempty"` // snapshots.restore_objects target schema
 }
 
 type RedisResponse struct {
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Env returns the trimmed value of key, or def when it is unset or blank.
+func Env(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// Path resolves a config file name against WATCHDOG_CONFIG_DIR (default /app/config).
+func Path(name string) string {
+	return filepath.Join(Env("WATCHDOG_CONFIG_DIR", "/app/config"), name)
+}
+
+// Load decodes the JSON config file into v. A missing file is not an error:
+// it returns false so callers can fall back to their built-in defaults.
+func Load(path string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("%s: %w", path, err)
+	}
+	return true, nil
+}
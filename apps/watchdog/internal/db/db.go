@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[string]*pgxpool.Pool)
+)
+
+// URL returns DATABASE_URL, or builds one from the POSTGRES_* variables
+// the rest of the stack already uses.
+func URL() string {
+	if u := config.Env("DATABASE_URL", ""); u != "" {
+		return u
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(config.Env("POSTGRES_USER", "supabase_admin"), config.Env("POSTGRES_PASSWORD", "")),
+		Host:     net.JoinHostPort(config.Env("POSTGRES_HOST", "db"), config.Env("POSTGRES_PORT", "5432")),
+		Path:     "/" + config.Env("POSTGRES_DB", "postgres"),
+		RawQuery: "sslmode=disable&application_name=watchdog",
+	}
+	return u.String()
+}
+
+type urlKey struct{}
+
+// WithURL makes Pool(ctx) connect to dbURL instead of URL(). An empty dbURL
+// marks a context without a direct database connection.
+func WithURL(ctx context.Context, dbURL string) context.Context {
+	return context.WithValue(ctx, urlKey{}, dbURL)
+}
+
+// Pool returns the shared connection pool of the database ctx is about
+// (see WithURL), connecting on first use.
+func Pool(ctx context.Context) (*pgxpool.Pool, error) {
+	dbURL, ok := ctx.Value(urlKey{}).(string)
+	if !ok {
+		dbURL = URL()
+	}
+	if dbURL == "" {
+		return nil, fmt.Errorf("no database url configured")
+	}
+	return PoolFor(ctx, dbURL)
+}
+
+// PoolFor returns the pool for one database url, creating it on first use.
+// Projects with their own database each get one.
+func PoolFor(ctx context.Context, dbURL string) (*pgxpool.Pool, error) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	if p, ok := pools[dbURL]; ok {
+		return p, nil
+	}
+	p, err := NewPool(ctx, dbURL, 5)
+	if err != nil {
+		return nil, err
+	}
+	pools[dbURL] = p
+	return p, nil
+}
+
+// NewPool opens a pool of its own, for callers such as the long-polling
+// queue consumers that would otherwise hold the shared connections.
+func NewPool(ctx context.Context, dbURL string, maxConns int32) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database url: %w", err)
+	}
+	cfg.MaxConns = maxConns
+	cfg.MaxConnIdleTime = 5 * time.Minute
+
+	p, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("🐘 [DB] Connection pool ready (%s, %d connections)", cfg.ConnConfig.Host, maxConns)
+	return p, nil
+}
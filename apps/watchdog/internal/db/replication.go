@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/jackc/pgx/v5"
+)
+
+// ReplicationUser is the least-privilege role base backups connect as.
+var ReplicationUser = config.Env("REPLICATION_USER", "watchdog_replication")
+
+// ReplicationPassword reads the role's password from a project's Docker
+// secret at path.
+func ReplicationPassword(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("replication secret: %w", err)
+	}
+	pw := strings.TrimSpace(string(b))
+	if pw == "" {
+		return "", fmt.Errorf("replication secret %s is empty", path)
+	}
+	return pw, nil
+}
+
+// EnsureReplicationRole creates the replication role if missing and keeps its
+// SCRAM verifier in sync with the secret. Only the verifier is sent to the
+// server, so the password never shows up in statement logs.
+func EnsureReplicationRole(ctx context.Context, password string) error {
+	pool, err := Pool(ctx)
+	if err != nil {
+		return err
+	}
+
+	var stored *string
+	var super, repl, login bool
+	err = pool.QueryRow(ctx,
+		`SELECT rolpassword, rolsuper, rolreplication, rolcanlogin FROM pg_authid WHERE rolname = $1`,
+		ReplicationUser).Scan(&stored, &super, &repl, &login)
+	ident := pgx.Identifier{ReplicationUser}.Sanitize()
+
+	switch {
+	case err == pgx.ErrNoRows:
+		verifier, err := scramVerifier(password)
+		if err != nil {
+			return err
+		}
+		log.Printf("🔑 [REPL] Creating replication role %s", ReplicationUser)
+		_, err = pool.Exec(ctx, fmt.Sprintf(`CREATE ROLE %s WITH LOGIN REPLICATION NOSUPERUSER NOCREATEDB NOCREATEROLE NOINHERIT PASSWORD '%s'`, ident, verifier))
+		return err
+	case err != nil:
+		return err
+	}
+
+	if super || !repl || !login {
+		log.Printf("🔑 [REPL] Resetting attributes of %s (superuser=%v replication=%v login=%v)", ReplicationUser, super, repl, login)
+		if _, err := pool.Exec(ctx, fmt.Sprintf(`ALTER ROLE %s WITH LOGIN REPLICATION NOSUPERUSER`, ident)); err != nil {
+			return err
+		}
+	}
+
+	if stored != nil && scramMatches(*stored, password) {
+		return nil
+	}
+	verifier, err := scramVerifier(password)
+	if err != nil {
+		return err
+	}
+	log.Printf("🔑 [REPL] Password of %s differs from the secret, rotating", ReplicationUser)
+	_, err = pool.Exec(ctx, fmt.Sprintf(`ALTER ROLE %s WITH PASSWORD '%s'`, ident, verifier))
+	return err
+}
+
+const scramIterations = 4096
+
+// scramVerifier builds the SCRAM-SHA-256 verifier Postgres stores in
+// pg_authid: SCRAM-SHA-256$<iter>:<salt>$<StoredKey>:<ServerKey>.
+func scramVerifier(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	stored, server, err := scramKeys(password, salt, scramIterations)
+	if err != nil {
+		return "", err
+	}
+	b64 := base64.StdEncoding.EncodeToString
+	return fmt.Sprintf("SCRAM-SHA-256$%d:%s$%s:%s", scramIterations, b64(salt), b64(stored), b64(server)), nil
+}
+
+// scramMatches reports whether a stored verifier belongs to password.
+func scramMatches(verifier, password string) bool {
+	rest, ok := strings.CutPrefix(verifier, "SCRAM-SHA-256$")
+	if !ok {
+		return false // md5 or cleartext: replace with SCRAM
+	}
+	params, keys, _ := strings.Cut(rest, "$")
+	iterStr, saltB64, _ := strings.Cut(params, ":")
+	storedB64, _, _ := strings.Cut(keys, ":")
+
+	iter, err := strconv.Atoi(iterStr)
+	salt, err2 := base64.StdEncoding.DecodeString(saltB64)
+	want, err3 := base64.StdEncoding.DecodeString(storedB64)
+	if err != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	got, _, err := scramKeys(password, salt, iter)
+	return err == nil && hmac.Equal(got, want)
+}
+
+func scramKeys(password string, salt []byte, iter int) (stored, server []byte, err error) {
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iter, sha256.Size)
+	if err != nil {
+		return nil, nil, err
+	}
+	mac := func(key []byte, msg string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(msg))
+		return h.Sum(nil)
+	}
+	clientKey := sha256.Sum256(mac(salted, "Client Key"))
+	return clientKey[:], mac(salted, "Server Key"), nil
+}
+
+// HBARule is one row of pg_hba_file_rules.
+type HBARule struct {
+	Line     int
+	Type     string
+	Database []string
+	Users    []string
+	Address  string
+	Netmask  string
+	Method   string
+	Error    string
+}
+
+// CheckReplicationHBA inspects the loaded pg_hba rules without editing them.
+// It returns problems worth reporting: parse errors, trust entries reachable
+// over the network, and whether the first rule matching a replication
+// connection from 127.0.0.1 for user requires a password.
+func CheckReplicationHBA(ctx context.Context, user string) ([]string, error) {
+	pool, err := Pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT coalesce(line_number, 0), coalesce(type, ''), coalesce(database, '{}'), coalesce(user_name, '{}'),
+		       coalesce(address, ''), coalesce(netmask, ''), coalesce(auth_method, ''), coalesce(error, '')
+		FROM pg_hba_file_rules ORDER BY rule_number`)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := pgx.CollectRows(rows, func(r pgx.CollectableRow) (HBARule, error) {
+		var h HBARule
+		err := r.Scan(&h.Line, &h.Type, &h.Database, &h.Users, &h.Address, &h.Netmask, &h.Method, &h.Error)
+		return h, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	var match *HBARule
+	for i, r := range rules {
+		if r.Error != "" {
+			problems = append(problems, fmt.Sprintf("line %d: %s", r.Line, r.Error))
+			continue
+		}
+		if r.Method == "trust" && strings.HasPrefix(r.Type, "host") {
+			problems = append(problems, fmt.Sprintf("line %d: insecure trust for %s %s from %s",
+				r.Line, strings.Join(r.Database, ","), strings.Join(r.Users, ","), r.Address))
+		}
+		if match == nil && r.matchesReplication(user, net.IPv4(127, 0, 0, 1)) {
+			match = &rules[i]
+		}
+	}
+
+	switch {
+	case match == nil:
+		problems = append(problems, fmt.Sprintf("no rule allows replication for %s from 127.0.0.1, add: host replication %s 127.0.0.1/32 scram-sha-256", user, user))
+	case match.Method == "reject":
+		problems = append(problems, fmt.Sprintf("line %d rejects replication for %s", match.Line, user))
+	case match.Method != "scram-sha-256" && match.Method != "md5":
+		problems = append(problems, fmt.Sprintf("line %d lets %s replicate with %q instead of scram-sha-256", match.Line, user, match.Method))
+	}
+	return problems, nil
+}
+
+func (r HBARule) matchesReplication(user string, ip net.IP) bool {
+	if !strings.HasPrefix(r.Type, "host") || r.Type == "hostssl" || r.Type == "hostgssenc" {
+		return false // the backup connects over plain TCP
+	}
+	if !contains(r.Database, "replication") {
+		return false
+	}
+	if !contains(r.Users, "all") && !contains(r.Users, user) {
+		return false
+	}
+	switch r.Address {
+	case "all", "samehost", "samenet":
+		return true
+	}
+	addr := net.ParseIP(r.Address)
+	if addr == nil {
+		return false // hostnames are not resolved here
+	}
+	mask := net.IPMask(net.ParseIP(r.Netmask).To4())
+	if r.Netmask == "" || mask == nil {
+		return addr.Equal(ip)
+	}
+	return addr.Mask(mask).Equal(ip.Mask(mask))
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Client talks to the Docker Engine API over the mounted unix socket.
+type Client struct {
+	http *http.Client
+}
+
+func New() *Client {
+	return NewWithTimeout(10 * time.Second)
+}
+
+// NewWithTimeout is used for slow calls such as /system/df or prunes.
+func NewWithTimeout(timeout time.Duration) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
+					return net.Dial("unix", "/var/run/docker.sock")
+				},
+			},
+			Timeout: timeout,
+		},
+	}
+}
+
+// Get decodes the JSON response of a GET request into out.
+func (c *Client) Get(path string, out interface{}) error {
+	return c.do(http.MethodGet, path, nil, out)
+}
+
+// Post sends a body-less POST and decodes the response into out (may be nil).
+func (c *Client) Post(path string, query url.Values, out interface{}) error {
+	return c.do(http.MethodPost, path, query, out)
+}
+
+// Delete removes a resource, e.g. /volumes/<name>.
+func (c *Client) Delete(path string, query url.Values) error {
+	return c.do(http.MethodDelete, path, query, nil)
+}
+
+func (c *Client) do(method, path string, query url.Values, out interface{}) error {
+	u := "http://localhost" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("docker %s %s: %d %s", method, path, resp.StatusCode, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
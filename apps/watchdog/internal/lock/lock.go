@@ -0,0 +1,228 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Policy decides what happens when a job's lock is already held.
+type Policy int
+
+const (
+	Skip  Policy = iota // give up and report the skipped run
+	Queue               // wait until the holder is done
+)
+
+// ErrBusy is returned with the Skip policy when another run holds the lock.
+type ErrBusy struct {
+	Name   string
+	Holder Holder
+}
+
+func (e *ErrBusy) Error() string {
+	return fmt.Sprintf("%s already running on %s since %s", e.Name, e.Holder.Owner, e.Holder.Since.Format("15:04:05"))
+}
+
+// Holder is what is stored under the lock key. Start tells a live owner from
+// a dead one whose pid was reused, e.g. pid 1 after a container restart.
+type Holder struct {
+	Owner string    `json:"owner"` // hostname/pid
+	Start uint64    `json:"start,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+const (
+	ttl       = 2 * time.Minute // renewed every ttl/3 while the job runs
+	pollEvery = 5 * time.Second
+	keyPrefix = "watchdog:lock:"
+)
+
+// Compare-and-delete / compare-and-extend / compare-and-swap so we never touch
+// another owner's lock.
+var (
+	releaseScript  = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+	extendScript   = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`)
+	takeoverScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3]) end return false`)
+)
+
+var (
+	rdb     *redis.Client
+	rdbOnce sync.Once
+	host, _ = os.Hostname()
+	owner   = fmt.Sprintf("%s/%d", host, os.Getpid())
+	start   = procStart(os.Getpid())
+	localMu sync.Mutex
+	held    = make(map[string]Holder) // locks held by this process
+)
+
+func client() *redis.Client {
+	rdbOnce.Do(func() {
+		rdb = redis.NewClient(&redis.Options{Addr: config.Env("REDIS_HOST", "redis") + ":6379"})
+	})
+	return rdb
+}
+
+// PolicyFor returns the configured policy for a job: LOCK_POLICY_<JOB>=skip|queue
+// overrides the default.
+func PolicyFor(job string, def Policy) Policy {
+	key := "LOCK_POLICY_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(job))
+	switch strings.ToLower(config.Env(key, "")) {
+	case "queue":
+		return Queue
+	case "skip":
+		return Skip
+	}
+	return def
+}
+
+// Acquire takes the named lock in this process and in Redis, so a second
+// watchdog instance cannot run the same job. If Redis is unreachable the
+// in-process lock still applies. The returned release func is idempotent.
+func Acquire(ctx context.Context, name string, policy Policy) (func(), error) {
+	me := Holder{Owner: owner, Start: start, Since: time.Now()}
+	value, _ := json.Marshal(me)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		h, busy := tryLocal(name, me)
+		if !busy {
+			ok, remote, err := tryRedis(ctx, name, string(value))
+			if err != nil {
+				log.Printf("⚠️ [LOCK] Redis unavailable for %s, using local lock only: %v", name, err)
+				ok = true
+			}
+			if ok {
+				return releaser(name, string(value)), nil
+			}
+			dropLocal(name)
+			h = remote
+		}
+
+		if policy == Skip {
+			return nil, &ErrBusy{Name: name, Holder: h}
+		}
+		log.Printf("⏳ [LOCK] %s held by %s, waiting...", name, h.Owner)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollEvery):
+		}
+	}
+}
+
+func tryLocal(name string, me Holder) (Holder, bool) {
+	localMu.Lock()
+	defer localMu.Unlock()
+	if h, ok := held[name]; ok {
+		return h, true
+	}
+	held[name] = me
+	return Holder{}, false
+}
+
+func dropLocal(name string) {
+	localMu.Lock()
+	delete(held, name)
+	localMu.Unlock()
+}
+
+func tryRedis(ctx context.Context, name, value string) (bool, Holder, error) {
+	key := keyPrefix + name
+	ok, err := client().SetNX(ctx, key, value, ttl).Result()
+	if err != nil || ok {
+		return ok, Holder{}, err
+	}
+	var h Holder
+	cur, err := client().Get(ctx, key).Result()
+	if err == nil {
+		json.Unmarshal([]byte(cur), &h)
+	}
+	// The CLI runs in the daemon's container and shares its hostname, so
+	// only a holder whose process is gone is taken over; anything else
+	// waits for the TTL.
+	if err == nil && dead(h) {
+		err := takeoverScript.Run(ctx, client(), []string{key}, cur, value, ttl.Milliseconds()).Err()
+		if err == nil {
+			log.Printf("♻️ [LOCK] Took over %s left by previous process %s (since %s)", name, h.Owner, h.Since.Format("15:04:05"))
+			return true, Holder{}, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			return false, h, err
+		}
+	}
+	return false, h, nil
+}
+
+// dead reports whether h was taken by a process in this pid namespace that no
+// longer runs: its pid is free or now belongs to a process started later.
+func dead(h Holder) bool {
+	hostname, pid, ok := strings.Cut(h.Owner, "/")
+	n, err := strconv.Atoi(pid)
+	if !ok || err != nil || hostname != host || start == 0 {
+		return false
+	}
+	now := procStart(n)
+	return now == 0 || h.Start != 0 && now != h.Start
+}
+
+// procStart returns when pid started, in clock ticks since boot (field 22 of
+// /proc/<pid>/stat), or 0 if there is no such process.
+func procStart(pid int) uint64 {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0
+	}
+	// The command name may contain spaces and parens; count fields after the last ")".
+	stat := string(b)
+	f := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(f) < 20 {
+		return 0
+	}
+	n, _ := strconv.ParseUint(f[19], 10, 64)
+	return n
+}
+
+func releaser(name, value string) func() {
+	key := keyPrefix + name
+	stop := make(chan struct{})
+
+	go func() {
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				err := extendScript.Run(context.Background(), client(), []string{key}, value, ttl.Milliseconds()).Err()
+				if err != nil && !errors.Is(err, redis.Nil) {
+					log.Printf("⚠️ [LOCK] Could not extend %s: %v", name, err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			releaseScript.Run(ctx, client(), []string{key}, value)
+			dropLocal(name)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// alerter tracks which named conditions are currently firing so a check can
+// report once, remind at a fixed interval and announce recovery.
+type alerter struct {
+	tg     *telegram.Service
+	repeat time.Duration
+
+	mu     sync.Mutex
+	firing map[string]time.Time // key -> last notification
+}
+
+func newAlerter(tg *telegram.Service, repeat time.Duration) *alerter {
+	return &alerter{tg: tg, repeat: repeat, firing: make(map[string]time.Time)}
+}
+
+// Fire sends msg unless key already alerted within the repeat interval.
+func (a *alerter) Fire(key, msg string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.firing[key]; ok && time.Since(last) < a.repeat {
+		return
+	}
+	a.firing[key] = time.Now()
+	a.tg.Send(msg)
+}
+
+// Resolve sends msg if key was firing and clears it. An empty msg clears silently.
+func (a *alerter) Resolve(key, msg string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.firing[key]; !ok {
+		return
+	}
+	delete(a.firing, key)
+	if msg != "" {
+		a.tg.Send(msg)
+	}
+}
@@ -1,41 +1,260 @@
 package monitor
 
 import (
+	"context"
 	"fmt"
-	"syscall"
 	"log"
+	"math"
+	"slices"
+	"syscall"
 	"time"
 
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
+const gb = 1024 * 1024 * 1024
+
+// DiskPath is one watched mount. Thresholds left at zero are not checked.
+type DiskPath struct {
+	Name        string  `json:"name"`
+	Path        string  `json:"path"`
+	MinFreeGB   float64 `json:"min_free_gb,omitempty"`
+	MaxUsedPct  float64 `json:"max_used_pct,omitempty"`
+	MaxInodePct float64 `json:"max_inode_pct,omitempty"`
+	// ForecastWarn alerts when the projected time until full drops below it (e.g. "6h").
+	ForecastWarn string `json:"forecast_warn,omitempty"`
+
+	forecast time.Duration
+}
+
+// DiskConfig is the format of disk.json.
+type DiskConfig struct {
+	Interval string     `json:"interval,omitempty"`
+	Samples  int        `json:"samples,omitempty"` // regression window
+	Paths    []DiskPath `json:"paths"`
+}
+
+var defaultDiskConfig = DiskConfig{
+	Interval: "1m",
+	Samples:  60,
+	Paths: []DiskPath{
+		{Name: "Root", Path: "/", MinFreeGB: 100, MaxInodePct: 90, ForecastWarn: "12h"},
+		{Name: "Backup dir", Path: "/app/backup", MaxUsedPct: 90, ForecastWarn: "12h"},
+		{Name: "WAL volume", Path: "/wal_archive", MaxUsedPct: 85, MaxInodePct: 90, ForecastWarn: "6h"},
+		{Name: "PITR workspace", Path: "/app/pitr", MaxUsedPct: 90, ForecastWarn: "12h"},
+		// Mounted read-only by docker-compose.yml
+		{Name: "Docker root", Path: "/host/var/lib/docker", MaxUsedPct: 90, ForecastWarn: "12h"},
+		{Name: "DB volume", Path: "/host/db-data", MaxUsedPct: 85, ForecastWarn: "12h"},
+	},
+}
+
+type diskLevel int
+
+const (
+	diskOK diskLevel = iota
+	diskWarn
+	diskCrit
+)
+
+type diskSample struct {
+	at   time.Time
+	used float64
+}
+
+// diskState is the alert state of one path; it replaces the old sleep-based backoff.
+type diskState struct {
+	level     diskLevel
+	lastAlert time.Time
+	samples   []diskSample
+}
+
 // WatchDisk replaces diskwatch.sh
-func WatchDisk(tg *telegram.Service) {
-	const minFreeGB = 100
-	ticker := time.NewTicker(1 * time.Minute)
-
-	for range ticker.C {
-		var stat syscall.Statfs_t
-		if err := syscall.Statfs("/", &stat); err != nil {
-			log.Println("Error checking disk:", err)
-			continue
-		}
-
-		// Available blocks * size / 1024^3
-		freeGB := (stat.Bavail * uint64(stat.Bsize)) / (1024 * 1024 * 1024)
-		usedPct := 100 - ((stat.Bavail * 100) / stat.Blocks)
-
-		if freeGB < minFreeGB {
-			// Simple logic: no more awkward awk math
-			msg := fmt.Sprintf("🚨 [DISKWATCH] Low Space: %dGB free (%d%% used)", freeGB, usedPct)
-			tg.Send(msg)
-			
-			// Dynamic backoff can be implemented here easily using time.Sleep
-			if freeGB < 10 {
-				time.Sleep(10 * time.Minute) // Panic mode: alert often
+func WatchDisk(ctx context.Context, tg *telegram.Service) {
+	// Decode into a zero config: decoding over the defaults would merge file
+	// entries into the built-in ones at the same index.
+	var cfg DiskConfig
+	file := config.Env("DISKWATCH_CONFIG_FILE", config.Path("disk.json"))
+	if _, err := config.Load(file, &cfg); err != nil {
+		log.Printf("❌ [DISKWATCH] %v, using defaults", err)
+		cfg = DiskConfig{}
+	}
+	if cfg.Interval == "" {
+		cfg.Interval = defaultDiskConfig.Interval
+	}
+	if cfg.Paths == nil {
+		cfg.Paths = slices.Clone(defaultDiskConfig.Paths)
+	}
+
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+	if cfg.Samples < 3 {
+		cfg.Samples = 60
+	}
+	for i := range cfg.Paths {
+		if cfg.Paths[i].Name == "" {
+			cfg.Paths[i].Name = cfg.Paths[i].Path
+		}
+		if p := &cfg.Paths[i]; p.ForecastWarn != "" {
+			if d, err := time.ParseDuration(p.ForecastWarn); err != nil || d <= 0 {
+				log.Printf("❌ [DISKWATCH] %s: invalid forecast_warn %q, forecast disabled", p.Name, p.ForecastWarn)
+				tg.Send(fmt.Sprintf("⚠️ [DISKWATCH] %s: invalid forecast_warn %q in %s, fill-up forecast disabled", p.Name, p.ForecastWarn, file))
 			} else {
-				time.Sleep(1 * time.Hour)    // Warning mode: alert hourly
+				p.forecast = d
 			}
 		}
 	}
-}
\ No newline at end of file
+
+	log.Printf("🔍 [DISKWATCH] Watching %d paths every %s", len(cfg.Paths), interval)
+	states := make(map[string]*diskState)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, p := range cfg.Paths {
+			st, ok := states[p.Path]
+			if !ok {
+				st = &diskState{}
+				states[p.Path] = st
+			}
+			checkDiskPath(tg, p, st, cfg.Samples)
+		}
+	}
+}
+
+func checkDiskPath(tg *telegram.Service, p DiskPath, st *diskState, maxSamples int) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(p.Path, &stat); err != nil {
+		log.Printf("Error checking disk %s: %v", p.Path, err)
+		return
+	}
+	if stat.Blocks == 0 {
+		return
+	}
+
+	total := float64(stat.Blocks) * float64(stat.Bsize)
+	free := float64(stat.Bavail) * float64(stat.Bsize)
+	used := total - float64(stat.Bfree)*float64(stat.Bsize)
+	freeGB := free / gb
+	usedPct := 100 - float64(stat.Bavail)*100/float64(stat.Blocks)
+	var inodePct float64
+	if stat.Files > 0 {
+		inodePct = 100 - float64(stat.Ffree)*100/float64(stat.Files)
+	}
+
+	now := time.Now()
+	st.samples = append(st.samples, diskSample{at: now, used: used})
+	if len(st.samples) > maxSamples {
+		st.samples = st.samples[len(st.samples)-maxSamples:]
+	}
+
+	level := diskOK
+	var reasons []string
+	if p.MinFreeGB > 0 && freeGB < p.MinFreeGB {
+		level = diskWarn
+		if freeGB < p.MinFreeGB/10 {
+			level = diskCrit
+		}
+		reasons = append(reasons, fmt.Sprintf("%.1fGB free (< %.0fGB)", freeGB, p.MinFreeGB))
+	}
+	if p.MaxUsedPct > 0 && usedPct >= p.MaxUsedPct {
+		level = max(level, diskWarn)
+		if usedPct >= 98 {
+			level = diskCrit
+		}
+		reasons = append(reasons, fmt.Sprintf("%.0f%% used", usedPct))
+	}
+	if p.MaxInodePct > 0 && inodePct >= p.MaxInodePct {
+		level = max(level, diskWarn)
+		reasons = append(reasons, fmt.Sprintf("%.0f%% inodes used", inodePct))
+	}
+	if eta, ok := timeUntilFull(st.samples, free); ok && p.forecast > 0 && eta < p.forecast {
+		level = max(level, diskWarn)
+		if eta < p.forecast/4 {
+			level = diskCrit
+		}
+		reasons = append(reasons, fmt.Sprintf("full in ~%s", formatETA(eta)))
+	}
+
+	prev := st.level
+	st.level = level
+
+	if level == diskOK {
+		if prev != diskOK {
+			tg.Send(fmt.Sprintf("✅ [DISKWATCH] %s (%s) back to normal: %.1fGB free (%.0f%% used)", p.Name, p.Path, freeGB, usedPct))
+		}
+		return
+	}
+
+	// Re-alert on escalation, otherwise at most every 10 minutes when critical
+	// and hourly when only warning.
+	repeat := time.Hour
+	if level == diskCrit {
+		repeat = 10 * time.Minute
+	}
+	if level <= prev && now.Sub(st.lastAlert) < repeat {
+		return
+	}
+	st.lastAlert = now
+
+	emoji := "⚠️"
+	if level == diskCrit {
+		emoji = "🚨"
+	}
+	msg := fmt.Sprintf("%s [DISKWATCH] %s (%s): %s", emoji, p.Name, p.Path, reasons[0])
+	for _, r := range reasons[1:] {
+		msg += ", " + r
+	}
+	tg.Send(msg)
+}
+
+// timeUntilFull fits a least-squares line through the used-bytes samples and
+// projects when the remaining free space is consumed. It returns false while
+// there is too little history or usage is not growing.
+func timeUntilFull(samples []diskSample, free float64) (time.Duration, bool) {
+	if len(samples) < 10 {
+		return 0, false
+	}
+	t0 := samples[0].at
+	var sumX, sumY, sumXY, sumXX float64
+	for _, s := range samples {
+		x := s.at.Sub(t0).Seconds()
+		sumX += x
+		sumY += s.used
+		sumXY += x * s.used
+		sumXX += x * x
+	}
+	n := float64(len(samples))
+	denom := n*sumXX - sumX*sumX
+	if denom == 0 {
+		return 0, false
+	}
+	slope := (n*sumXY - sumX*sumY) / denom // bytes per second
+	if slope <= 0 {
+		return 0, false
+	}
+	secs := free / slope
+	if math.IsInf(secs, 0) || secs > float64(365*24*3600) {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
+
+func formatETA(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(math.Max(1, d.Minutes())))
+	}
+}
@@ -2,14 +2,12 @@ package monitor
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net"
-	"net/http"
 	"strings"
 	"time"
 
+	"github.com/GoldenCarrotMLP/watchdog/internal/docker"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
@@ -24,36 +22,27 @@ type containerInfo struct {
 	} `json:"Health,omitempty"`
 }
 
-func WatchContainers(tg *telegram.Service) {
+func WatchContainers(ctx context.Context, tg *telegram.Service) {
 	log.Println("🔍 [MONITOR] Docker socket health watcher started (20s interval)")
 
-	// Create an HTTP client that talks to the Unix socket
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-				return net.Dial("unix", "/var/run/docker.sock")
-			},
-		},
-		Timeout: 10 * time.Second,
-	}
+	client := docker.New()
 
 	ticker := time.NewTicker(20 * time.Second)
-	for range ticker.C {
-		// 1. Get all containers (all=1 includes stopped containers)
-		resp, err := httpClient.Get("http://localhost/containers/json?all=1")
-		if err != nil {
-			log.Printf("❌ [DOCKER] Socket Error: %v", err)
-			tg.Send(fmt.Sprintf("🛑 Watchdog: Docker API error at %s", time.Now().Format(time.Kitchen)))
-			continue
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
+		// 1. Get all containers (all=1 includes stopped containers)
 		var containers []containerInfo
-		if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
-			log.Printf("❌ [DOCKER] JSON Decode Error: %v", err)
-			resp.Body.Close()
+		if err := client.Get("/containers/json?all=1", &containers); err != nil {
+			log.Printf("❌ [DOCKER] Socket Error: %v", err)
+			tg.Send(fmt.Sprintf("🛑 Watchdog: Docker API error at %s", time.Now().Format(time.Kitchen)))
 			continue
 		}
-		resp.Body.Close()
 
 		// 2. Loop through containers and mimic shell script logic
 		for _, c := range containers {
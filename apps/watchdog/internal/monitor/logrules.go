@@ -0,0 +1,243 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"text/template"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+)
+
+// LogRule is one entry of the logwatch rules file (or of a built-in pack).
+// Match and Exclude are regular expressions evaluated against a single log line,
+// Containers are glob selectors over container names (e.g. "supabase-*", "*" for
+// all) and at least one is required.
+type LogRule struct {
+	Name        string   `json:"name"`
+	Containers  []string `json:"containers"`
+	Match       string   `json:"match"`
+	Exclude     []string `json:"exclude,omitempty"`
+	Severity    string   `json:"severity"`
+	DedupWindow string   `json:"dedup_window,omitempty"` // e.g. "15m"
+	Message     string   `json:"message,omitempty"`      // text/template, see LogHit
+
+	match    *regexp.Regexp
+	exclude  []*regexp.Regexp
+	dedup    time.Duration
+	template *template.Template
+}
+
+// LogRulesFile is the on-disk format: a list of built-in packs to enable plus custom rules.
+type LogRulesFile struct {
+	Packs []string  `json:"packs"`
+	Rules []LogRule `json:"rules"`
+}
+
+// LogHit is what a rule message template is rendered with.
+type LogHit struct {
+	Rule      string
+	Container string
+	Severity  string
+	Emoji     string
+	Count     int
+	Line      string
+}
+
+const defaultLogMessage = "{{.Emoji}} [LOGWATCH] {{.Rule}} in {{.Container}} ({{.Count}}x)\n{{.Line}}"
+
+// BuiltinLogPacks are the rule packs shipped with watchdog.
+var BuiltinLogPacks = map[string][]LogRule{
+	"postgres-corruption": {
+		{
+			Name:       "postgres-corruption",
+			Containers: []string{"supabase-db"},
+			Match:      `(?i)(invalid record length|could not read block|invalid page in block|page verification failed|checksum verification failed|wal corruption|database files are incompatible)`,
+			Severity:   "critical",
+			Message:    "🛑 [LOGWATCH] Potential corruption in {{.Container}}! ({{.Count}}x)\n{{.Line}}",
+		},
+		{
+			Name:        "postgres-panic",
+			Containers:  []string{"supabase-db"},
+			Match:       `\b(PANIC|FATAL):`,
+			Exclude:     []string{`(?i)terminating connection`, `(?i)database system is (starting up|shutting down)`, `(?i)password authentication failed`, `(?i)role ".*" does not exist`},
+			Severity:    "critical",
+			DedupWindow: "10m",
+		},
+	},
+	"gotrue-auth": {
+		{
+			Name:        "gotrue-error",
+			Containers:  []string{"supabase-auth"},
+			Match:       `"level":"(error|fatal|panic)"`,
+			Exclude:     []string{`(?i)invalid login credentials`, `(?i)email not confirmed`, `(?i)refresh token not found`},
+			Severity:    "warning",
+			DedupWindow: "30m",
+		},
+		{
+			Name:       "gotrue-smtp",
+			Containers: []string{"supabase-auth"},
+			Match:      `(?i)(error sending (confirmation|recovery|magic link|invite) email|smtp)`,
+			Exclude:    []string{`"level":"(info|debug)"`},
+			Severity:   "warning",
+		},
+	},
+	"kong-upstream": {
+		{
+			Name:        "kong-upstream",
+			Containers:  []string{"supabase-kong"},
+			Match:       `(?i)(upstream (timed out|prematurely closed)|connect\(\) failed|no live upstreams|failed to (connect|resolve))`,
+			Severity:    "warning",
+			DedupWindow: "15m",
+		},
+		{
+			Name:        "kong-5xx",
+			Containers:  []string{"supabase-kong"},
+			Match:       `" (502|503|504) \d+`,
+			Severity:    "info",
+			DedupWindow: "30m",
+		},
+	},
+}
+
+// LoadLogRules reads LOGWATCH_RULES_FILE (default <config dir>/logwatch.json).
+// Without a file every built-in pack is enabled. The postgres-corruption pack
+// also watches the database container of every loaded project.
+func LoadLogRules() ([]*LogRule, error) {
+	file := config.Env("LOGWATCH_RULES_FILE", config.Path("logwatch.json"))
+
+	var cfg LogRulesFile
+	found, err := config.Load(file, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		for name := range BuiltinLogPacks {
+			cfg.Packs = append(cfg.Packs, name)
+		}
+	}
+
+	var raw []LogRule
+	for _, p := range cfg.Packs {
+		pack, ok := BuiltinLogPacks[p]
+		if !ok {
+			return nil, fmt.Errorf("unknown rule pack %q", p)
+		}
+		for _, r := range pack {
+			if p == "postgres-corruption" {
+				r.Containers = append(slices.Clone(r.Containers), databaseContainers(r.Containers)...)
+			}
+			raw = append(raw, r)
+		}
+	}
+	raw = append(raw, cfg.Rules...)
+
+	rules := make([]*LogRule, 0, len(raw))
+	for i := range raw {
+		r := raw[i]
+		if err := r.compile(); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		rules = append(rules, &r)
+	}
+	return rules, nil
+}
+
+// databaseContainers lists the projects' database containers not already in known.
+func databaseContainers(known []string) []string {
+	var extra []string
+	for _, p := range project.All() {
+		if p.DBContainer != "" && !slices.Contains(known, p.DBContainer) && !slices.Contains(extra, p.DBContainer) {
+			extra = append(extra, p.DBContainer)
+		}
+	}
+	return extra
+}
+
+func (r *LogRule) compile() error {
+	var err error
+	if r.Match == "" {
+		return fmt.Errorf("match is required")
+	}
+	if len(r.Containers) == 0 {
+		return fmt.Errorf("containers is required, use [\"*\"] for every container")
+	}
+	if r.match, err = regexp.Compile(r.Match); err != nil {
+		return err
+	}
+	for _, ex := range r.Exclude {
+		re, err := regexp.Compile(ex)
+		if err != nil {
+			return err
+		}
+		r.exclude = append(r.exclude, re)
+	}
+	for _, sel := range r.Containers {
+		if _, err := path.Match(sel, ""); err != nil {
+			return fmt.Errorf("bad container selector %q: %w", sel, err)
+		}
+	}
+
+	r.dedup = 5 * time.Minute
+	if r.DedupWindow != "" {
+		if r.dedup, err = time.ParseDuration(r.DedupWindow); err != nil {
+			return err
+		}
+	}
+
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	msg := r.Message
+	if msg == "" {
+		msg = defaultLogMessage
+	}
+	r.template, err = template.New(r.Name).Parse(msg)
+	return err
+}
+
+// AppliesTo reports whether the rule selects the given container.
+func (r *LogRule) AppliesTo(container string) bool {
+	for _, sel := range r.Containers {
+		if ok, _ := path.Match(sel, container); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches checks a single line: it must match and hit none of the exclusions.
+func (r *LogRule) Matches(line string) bool {
+	if !r.match.MatchString(line) {
+		return false
+	}
+	for _, ex := range r.exclude {
+		if ex.MatchString(line) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *LogRule) render(hit LogHit) string {
+	var buf bytes.Buffer
+	if err := r.template.Execute(&buf, hit); err != nil {
+		return fmt.Sprintf("%s [LOGWATCH] %s in %s: %s", hit.Emoji, hit.Rule, hit.Container, hit.Line)
+	}
+	return buf.String()
+}
+
+func severityEmoji(sev string) string {
+	switch sev {
+	case "critical":
+		return "🛑"
+	case "warning":
+		return "⚠️"
+	default:
+		return "ℹ️"
+	}
+}
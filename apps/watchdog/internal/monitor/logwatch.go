@@ -1,39 +1,113 @@
 package monitor
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"log"
 	"os/exec"
 	"strings"
 	"time"
+	"unicode/utf8"
+
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
-func WatchLogs(tg *telegram.Service) {
-	containerName := "supabase-db"
-	patterns := []string{"invalid record length", "could not read block", "wal corruption", "database files are incompatible", "FATAL", "PANIC"}
+// WatchLogs tails every container selected by a log rule once a minute and
+// alerts on lines that match a rule without hitting one of its exclusions.
+func WatchLogs(ctx context.Context, tg *telegram.Service) {
+	rules, err := LoadLogRules()
+	if err != nil {
+		log.Printf("❌ [LOGWATCH] Invalid rules file: %v", err)
+		tg.Send(fmt.Sprintf("⚠️ [LOGWATCH] Rules file invalid, log watching disabled: %v", err))
+		return
+	}
+	log.Printf("🔍 [LOGWATCH] Loaded %d rules", len(rules))
+
+	lastAlert := make(map[string]time.Time) // rule|container -> last alert
+	since := time.Now().Add(-1 * time.Minute)
 
 	ticker := time.NewTicker(1 * time.Minute)
-	for range ticker.C {
-		out, err := exec.Command("docker", "logs", "--tail", "200", containerName).CombinedOutput()
-		if err != nil {
-			continue
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 
-		logStr := strings.ToLower(string(out))
-		var matches []string
+		now := time.Now()
+		for _, container := range runningContainers() {
+			var active []*LogRule
+			for _, r := range rules {
+				if r.AppliesTo(container) {
+					active = append(active, r)
+				}
+			}
+			if len(active) == 0 {
+				continue
+			}
+
+			out, err := exec.Command("docker", "logs", "--since", fmt.Sprint(since.Unix()), container).CombinedOutput()
+			if err != nil {
+				continue
+			}
+
+			hits := make(map[*LogRule]*LogHit)
+			scanner := bufio.NewScanner(bytes.NewReader(out))
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				line := scanner.Text()
+				for _, r := range active {
+					if !r.Matches(line) {
+						continue
+					}
+					if h, ok := hits[r]; ok {
+						h.Count++
+						continue
+					}
+					hits[r] = &LogHit{
+						Rule:      r.Name,
+						Container: container,
+						Severity:  r.Severity,
+						Emoji:     severityEmoji(r.Severity),
+						Count:     1,
+						Line:      truncate(strings.TrimSpace(line), 300),
+					}
+				}
+			}
 
-		for _, p := range patterns {
-			if strings.Contains(logStr, strings.ToLower(p)) {
-				// Exclude noise
-				if strings.Contains(logStr, "terminating connection") || strings.Contains(logStr, "database system is starting up") {
+			for r, hit := range hits {
+				key := r.Name + "|" + container
+				if t, ok := lastAlert[key]; ok && now.Sub(t) < r.dedup {
+					log.Printf("🔇 [LOGWATCH] %s suppressed (%d lines, dedup window)", key, hit.Count)
 					continue
 				}
-				matches = append(matches, p)
+				lastAlert[key] = now
+				tg.Send(r.render(*hit))
 			}
 		}
+		since = now
+	}
+}
 
-		if len(matches) > 0 {
-			tg.Send(fmt.Sprintf("🛑 [LOGWATCH] Potential corruption in %s!\nMatches: %s", containerName, strings.Join(matches, ", ")))
-		}
+func runningContainers() []string {
+	out, err := exec.Command("docker", "ps", "--format", "{{.Names}}").Output()
+	if err != nil {
+		log.Printf("⚠️ [LOGWATCH] Cannot list containers: %v", err)
+		return nil
+	}
+	return strings.Fields(string(out))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// Cut on a rune boundary so the alert stays valid UTF-8.
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-}
\ No newline at end of file
+	return s[:n] + "…"
+}
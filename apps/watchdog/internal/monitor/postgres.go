@@ -0,0 +1,332 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/db"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresConfig holds the thresholds of postgres.json. Durations are Go
+// duration strings; a zero or empty threshold disables that check.
+type PostgresConfig struct {
+	Interval        string  `json:"interval"`
+	MaxConnPct      float64 `json:"max_conn_pct"`
+	LongRunning     string  `json:"long_running"`
+	IdleInTx        string  `json:"idle_in_transaction"`
+	LockWait        string  `json:"lock_wait"`
+	SlotLagMB       float64 `json:"slot_lag_mb"`
+	GrowthMBPerHour float64 `json:"growth_mb_per_hour"`
+}
+
+var defaultPostgresConfig = PostgresConfig{
+	Interval:        "1m",
+	MaxConnPct:      80,
+	LongRunning:     "15m",
+	IdleInTx:        "5m",
+	LockWait:        "1m",
+	SlotLagMB:       2048,
+	GrowthMBPerHour: 1024,
+}
+
+type pgWatcher struct {
+	pool   *pgxpool.Pool
+	alerts *alerter
+
+	maxConnPct      float64
+	longRunning     time.Duration
+	idleInTx        time.Duration
+	lockWait        time.Duration
+	slotLagBytes    float64
+	growthPerHour   float64
+	lastFailedCount int64
+	sizeSamples     []diskSample
+}
+
+// WatchPostgres connects to the database directly and checks saturation,
+// stuck sessions, lock waits, slot lag, archiver failures and size growth.
+func WatchPostgres(ctx context.Context, tg *telegram.Service) {
+	cfg := defaultPostgresConfig
+	file := config.Env("PGWATCH_CONFIG_FILE", config.Path("postgres.json"))
+	if _, err := config.Load(file, &cfg); err != nil {
+		log.Printf("❌ [PGWATCH] %v, using defaults", err)
+		cfg = defaultPostgresConfig
+	}
+	// A typo such as "10 m" must not silently disable a check.
+	duration := func(key, s, def string) time.Duration {
+		d, err := parseDuration(s)
+		if err != nil {
+			log.Printf("❌ [PGWATCH] Invalid %s %q, using %s", key, s, def)
+			tg.Send(fmt.Sprintf("⚠️ [PGWATCH] Invalid %s %q in %s, using the default %s", key, s, file, def))
+			d, _ = parseDuration(def)
+		}
+		return d
+	}
+
+	pool, err := db.Pool(ctx)
+	if err != nil {
+		log.Printf("❌ [PGWATCH] %v", err)
+		tg.Send(fmt.Sprintf("⚠️ [PGWATCH] Postgres monitoring disabled: %v", err))
+		return
+	}
+
+	w := &pgWatcher{
+		pool:            pool,
+		alerts:          newAlerter(tg, 30*time.Minute),
+		maxConnPct:      cfg.MaxConnPct,
+		longRunning:     duration("long_running", cfg.LongRunning, defaultPostgresConfig.LongRunning),
+		idleInTx:        duration("idle_in_transaction", cfg.IdleInTx, defaultPostgresConfig.IdleInTx),
+		lockWait:        duration("lock_wait", cfg.LockWait, defaultPostgresConfig.LockWait),
+		slotLagBytes:    cfg.SlotLagMB * 1024 * 1024,
+		growthPerHour:   cfg.GrowthMBPerHour * 1024 * 1024,
+		lastFailedCount: -1,
+	}
+
+	interval := duration("interval", cfg.Interval, defaultPostgresConfig.Interval)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	log.Printf("🔍 [PGWATCH] Postgres health checks started (%s interval)", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		w.run(checkCtx)
+		cancel()
+	}
+}
+
+func (w *pgWatcher) run(ctx context.Context) {
+	if err := w.pool.Ping(ctx); err != nil {
+		log.Printf("❌ [PGWATCH] Ping failed: %v", err)
+		w.alerts.Fire("unreachable", fmt.Sprintf("🛑 [PGWATCH] Cannot reach Postgres: %v", err))
+		return
+	}
+	w.alerts.Resolve("unreachable", "✅ [PGWATCH] Postgres reachable again")
+
+	checks := []struct {
+		name string
+		fn   func(context.Context) error
+	}{
+		{"connections", w.checkConnections},
+		{"sessions", w.checkSessions},
+		{"locks", w.checkLocks},
+		{"slots", w.checkSlots},
+		{"archiver", w.checkArchiver},
+		{"size", w.checkSize},
+	}
+	for _, c := range checks {
+		if err := c.fn(ctx); err != nil {
+			log.Printf("⚠️ [PGWATCH] %s check failed: %v", c.name, err)
+		}
+	}
+}
+
+func (w *pgWatcher) checkConnections(ctx context.Context) error {
+	if w.maxConnPct <= 0 {
+		return nil
+	}
+	var used, limit int
+	err := w.pool.QueryRow(ctx,
+		`SELECT count(*), current_setting('max_connections')::int FROM pg_stat_activity`).Scan(&used, &limit)
+	if err != nil {
+		return err
+	}
+	pct := float64(used) * 100 / float64(limit)
+	if pct >= w.maxConnPct {
+		w.alerts.Fire("connections", fmt.Sprintf("⚠️ [PGWATCH] Connection saturation: %d/%d (%.0f%%)", used, limit, pct))
+	} else {
+		w.alerts.Resolve("connections", fmt.Sprintf("✅ [PGWATCH] Connections back to %d/%d", used, limit))
+	}
+	return nil
+}
+
+func (w *pgWatcher) checkSessions(ctx context.Context) error {
+	type check struct {
+		key   string
+		label string
+		limit time.Duration
+		where string
+	}
+	for _, c := range []check{
+		{"long-running", "Long-running queries", w.longRunning, `state = 'active' AND now() - query_start > $1 * interval '1 second'`},
+		{"idle-in-tx", "Idle-in-transaction sessions", w.idleInTx, `state LIKE 'idle in transaction%' AND now() - state_change > $1 * interval '1 second'`},
+	} {
+		if c.limit <= 0 {
+			continue
+		}
+		rows, err := w.pool.Query(ctx, `
+			SELECT pid, coalesce(usename, ''), coalesce(datname, ''),
+			       extract(epoch FROM now() - coalesce(xact_start, query_start))::float8,
+			       left(regexp_replace(query, '\s+', ' ', 'g'), 100)
+			FROM pg_stat_activity
+			WHERE backend_type = 'client backend' AND pid <> pg_backend_pid() AND `+c.where+`
+			ORDER BY 4 DESC LIMIT 5`, c.limit.Seconds())
+		if err != nil {
+			return err
+		}
+		var lines []string
+		for rows.Next() {
+			var pid int
+			var user, dbName, query string
+			var age float64
+			if err := rows.Scan(&pid, &user, &dbName, &age, &query); err != nil {
+				rows.Close()
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("• pid %d %s@%s %s: %s", pid, user, dbName, time.Duration(age)*time.Second, query))
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		if len(lines) > 0 {
+			w.alerts.Fire(c.key, fmt.Sprintf("⚠️ [PGWATCH] %s (> %s):\n%s", c.label, c.limit, strings.Join(lines, "\n")))
+		} else {
+			w.alerts.Resolve(c.key, "")
+		}
+	}
+	return nil
+}
+
+func (w *pgWatcher) checkLocks(ctx context.Context) error {
+	if w.lockWait <= 0 {
+		return nil
+	}
+	rows, err := w.pool.Query(ctx, `
+		SELECT pid, pg_blocking_pids(pid)::text,
+		       extract(epoch FROM now() - query_start)::float8,
+		       left(regexp_replace(query, '\s+', ' ', 'g'), 100)
+		FROM pg_stat_activity
+		WHERE wait_event_type = 'Lock' AND now() - query_start > $1 * interval '1 second'
+		ORDER BY 3 DESC LIMIT 5`, w.lockWait.Seconds())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var lines []string
+	for rows.Next() {
+		var pid int
+		var blockers, query string
+		var age float64
+		if err := rows.Scan(&pid, &blockers, &age, &query); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("• pid %d waiting %s on %s: %s", pid, time.Duration(age)*time.Second, blockers, query))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(lines) > 0 {
+		w.alerts.Fire("locks", fmt.Sprintf("🔒 [PGWATCH] Lock waits (> %s):\n%s", w.lockWait, strings.Join(lines, "\n")))
+	} else {
+		w.alerts.Resolve("locks", "")
+	}
+	return nil
+}
+
+func (w *pgWatcher) checkSlots(ctx context.Context) error {
+	if w.slotLagBytes <= 0 {
+		return nil
+	}
+	rows, err := w.pool.Query(ctx, `
+		SELECT slot_name, active, pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn)::float8
+		FROM pg_replication_slots WHERE restart_lsn IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var active bool
+		var lag float64
+		if err := rows.Scan(&name, &active, &lag); err != nil {
+			return err
+		}
+		key := "slot:" + name
+		if lag >= w.slotLagBytes {
+			w.alerts.Fire(key, fmt.Sprintf("⚠️ [PGWATCH] Replication slot %s retains %.0f MB of WAL (active: %v)", name, lag/1024/1024, active))
+		} else {
+			w.alerts.Resolve(key, fmt.Sprintf("✅ [PGWATCH] Replication slot %s caught up", name))
+		}
+	}
+	return rows.Err()
+}
+
+func (w *pgWatcher) checkArchiver(ctx context.Context) error {
+	var failed int64
+	var lastFailed, lastArchived string
+	var failing bool
+	err := w.pool.QueryRow(ctx, `
+		SELECT failed_count, coalesce(last_failed_wal, ''), coalesce(last_archived_wal, ''),
+		       coalesce(last_failed_time > coalesce(last_archived_time, 'epoch'), false)
+		FROM pg_stat_archiver`).Scan(&failed, &lastFailed, &lastArchived, &failing)
+	if err != nil {
+		return err
+	}
+
+	prev := w.lastFailedCount
+	w.lastFailedCount = failed
+	if failing || (prev >= 0 && failed > prev) {
+		w.alerts.Fire("archiver", fmt.Sprintf("🛑 [PGWATCH] WAL archiving failing: %d failures, last failed WAL %s (last archived %s)", failed, lastFailed, lastArchived))
+	} else {
+		w.alerts.Resolve("archiver", fmt.Sprintf("✅ [PGWATCH] WAL archiving recovered, last archived %s", lastArchived))
+	}
+	return nil
+}
+
+func (w *pgWatcher) checkSize(ctx context.Context) error {
+	if w.growthPerHour <= 0 {
+		return nil
+	}
+	var size float64
+	err := w.pool.QueryRow(ctx,
+		`SELECT sum(pg_database_size(datname))::float8 FROM pg_database WHERE NOT datistemplate`).Scan(&size)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	w.sizeSamples = append(w.sizeSamples, diskSample{at: now, used: size})
+	for len(w.sizeSamples) > 0 && now.Sub(w.sizeSamples[0].at) > time.Hour {
+		w.sizeSamples = w.sizeSamples[1:]
+	}
+
+	oldest := w.sizeSamples[0]
+	elapsed := now.Sub(oldest.at)
+	if elapsed < 10*time.Minute {
+		return nil
+	}
+	perHour := (size - oldest.used) / elapsed.Hours()
+	if perHour >= w.growthPerHour {
+		w.alerts.Fire("growth", fmt.Sprintf("📈 [PGWATCH] Database growing %.0f MB/h (total %.1f GB)", perHour/1024/1024, size/gb))
+	} else {
+		w.alerts.Resolve("growth", "")
+	}
+	return nil
+}
+
+// parseDuration parses a Go duration string; empty means zero.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,115 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/db"
+	"github.com/GoldenCarrotMLP/watchdog/internal/metrics"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+)
+
+// On a quiet database the current segment can stay open for hours: nothing
+// is archived, the reconciler sees no lag, yet those writes exist only on
+// the server. The freshness check measures that exposure and closes the
+// segment with pg_switch_wal() once it grows past WAL_SWITCH_AFTER.
+
+// WALFreshness is the part of the WAL stream not yet handed to the archiver.
+type WALFreshness struct {
+	Pending        bool          // writes in the current, unarchived segment
+	Age            time.Duration // since the last segment was archived, 0 when nothing is pending
+	ArchiveTimeout time.Duration // 0 when archive_timeout is disabled
+}
+
+// CheckWALFreshness reads the current segment offset and archive_timeout.
+func CheckWALFreshness(ctx context.Context, lastArchived time.Time) (WALFreshness, error) {
+	var f WALFreshness
+	pool, err := db.Pool(ctx)
+	if err != nil {
+		return f, err
+	}
+	var offset int64
+	var timeoutSecs int
+	err = pool.QueryRow(ctx, `
+		SELECT (pg_walfile_name_offset(pg_current_wal_lsn())).file_offset,
+		       (SELECT setting::int FROM pg_settings WHERE name = 'archive_timeout')`).Scan(&offset, &timeoutSecs)
+	if err != nil {
+		return f, err
+	}
+	f.ArchiveTimeout = time.Duration(timeoutSecs) * time.Second
+	f.Pending = offset > 0
+	if f.Pending && !lastArchived.IsZero() {
+		f.Age = time.Since(lastArchived)
+	}
+	return f, nil
+}
+
+// SwitchWAL closes the current segment so the archiver picks it up.
+func SwitchWAL(ctx context.Context) (string, error) {
+	pool, err := db.Pool(ctx)
+	if err != nil {
+		return "", err
+	}
+	var lsn string
+	err = pool.QueryRow(ctx, `SELECT pg_switch_wal()::text`).Scan(&lsn)
+	return lsn, err
+}
+
+// enforceWALFreshness runs after each reconcile: it forces a segment switch
+// when the open segment is older than switchAfter, warns when archive_timeout
+// is off or slower than that, and alerts when writes stay unarchived past the
+// budget even so. Age counts from the last archived segment, so after an idle
+// spell the first write already looks old: the alert waits until a forced
+// switch had a full check interval to produce a new archived segment.
+// switched carries the time of that switch between runs. Archived-but-
+// unshipped segments are the "rpo" alert's job.
+func enforceWALFreshness(ctx context.Context, st WALStatus, alerts *alerter, switched *time.Time, switchAfter, budget time.Duration) {
+	f, err := CheckWALFreshness(ctx, st.LastArchivedTime)
+	if err != nil {
+		log.Printf("⚠️ [WALSYNC] Freshness check failed: %v", err)
+		return
+	}
+	name := project.From(ctx).Name
+	metrics.WALUnarchivedAge.WithLabelValues(name).Set(f.Age.Seconds())
+
+	if f.ArchiveTimeout <= 0 || f.ArchiveTimeout > switchAfter {
+		setting := "disabled"
+		if f.ArchiveTimeout > 0 {
+			setting = f.ArchiveTimeout.String()
+		}
+		alerts.Fire("archive_timeout", fmt.Sprintf("⚠️ [WALSYNC] archive_timeout is %s, idle segments rely on forced switches every %s\n• Fix: ALTER SYSTEM SET archive_timeout = '60s'; SELECT pg_reload_conf();", setting, switchAfter))
+	} else {
+		alerts.Resolve("archive_timeout", "")
+	}
+
+	// A segment archived since the switch means the switch worked.
+	if !switched.IsZero() && !st.LastArchivedTime.Before(*switched) {
+		*switched = time.Time{}
+	}
+	stuck := !switched.IsZero() // switched in an earlier run, still nothing archived
+	if f.Pending && f.Age > switchAfter {
+		lsn, err := SwitchWAL(ctx)
+		if err != nil {
+			log.Printf("❌ [WALSYNC] pg_switch_wal failed: %v", err)
+			stuck = true
+		} else {
+			metrics.WALForcedSwitches.WithLabelValues(name).Inc()
+			log.Printf("🔀 [WALSYNC] Current segment open for %s, forced WAL switch at %s", f.Age.Round(time.Second), lsn)
+			if switched.IsZero() {
+				*switched = time.Now()
+			}
+		}
+	}
+
+	if f.Age <= budget {
+		alerts.Resolve("freshness", "✅ [WALSYNC] WAL freshness back within SLO")
+		return
+	}
+	if !stuck {
+		return // the switch just made gets until the next check
+	}
+	alerts.Fire("freshness", fmt.Sprintf("🚨 [WALSYNC] Writes from the last %s have not reached remote storage (SLO %s)\n• Last archived: %s at %s\n• Forced switches are not producing new segments, check the archiver",
+		f.Age.Round(time.Second), budget, st.LastArchivedWAL, st.LastArchivedTime.Format("15:04:05")))
+}
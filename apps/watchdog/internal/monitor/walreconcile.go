@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/db"
+	"github.com/GoldenCarrotMLP/watchdog/internal/metrics"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// WALStatus is one reconciliation of Postgres' archiver against the project's
+// local WAL backlog and its remote WAL folders.
+type WALStatus struct {
+	LastArchivedWAL  string
+	LastArchivedTime time.Time
+	LocalBacklog     int
+	OldestLocal      time.Time
+	InLocal          bool // newest archived segment still waiting for upload
+	InRemote         bool
+	Lag              time.Duration
+}
+
+// ReconcileWAL compares pg_stat_archiver with the local backlog and the remote listing.
+func ReconcileWAL(ctx context.Context) (WALStatus, error) {
+	var st WALStatus
+
+	pool, err := db.Pool(ctx)
+	if err != nil {
+		return st, err
+	}
+	var lastTime *time.Time
+	err = pool.QueryRow(ctx,
+		`SELECT coalesce(last_archived_wal, ''), last_archived_time FROM pg_stat_archiver`).Scan(&st.LastArchivedWAL, &lastTime)
+	if err != nil {
+		return st, err
+	}
+	if lastTime != nil {
+		st.LastArchivedTime = *lastTime
+	}
+
+	p := project.From(ctx)
+	entries, _ := os.ReadDir(p.WALDir)
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		st.LocalBacklog++
+		if e.Name() == st.LastArchivedWAL {
+			st.InLocal = true
+		}
+		if info, err := e.Info(); err == nil && (st.OldestLocal.IsZero() || info.ModTime().Before(st.OldestLocal)) {
+			st.OldestLocal = info.ModTime()
+		}
+	}
+
+	if st.LastArchivedWAL == "" {
+		return st, nil
+	}
+
+	// The uploader records the newest file it shipped. That covers segments
+	// already folded into a day archive, whose raw WAL/ folder is gone.
+	now := time.Now()
+	if c := p.ConfirmedWAL(); c != "" && c >= st.LastArchivedWAL {
+		st.InRemote = true
+	}
+
+	// Otherwise (e.g. right after a restart) look the segment up: the uploader
+	// files segments under the day they were uploaded, so look at today first
+	// and fall back to yesterday around midnight.
+	for _, day := range []time.Time{now, now.AddDate(0, 0, -1)} {
+		if st.InRemote {
+			break
+		}
+		remote := p.WALRemote(day.Format("2006-01-02"), "WAL", st.LastArchivedWAL)
+		out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "lsf", remote).Output()
+		if err == nil && strings.TrimSpace(string(out)) != "" {
+			st.InRemote = true
+			break
+		}
+	}
+
+	if !st.InRemote && !st.LastArchivedTime.IsZero() {
+		st.Lag = now.Sub(st.LastArchivedTime)
+	}
+	if !st.OldestLocal.IsZero() {
+		st.Lag = max(st.Lag, now.Sub(st.OldestLocal))
+	}
+	return st, nil
+}
+
+// WatchWALArchiving alerts when the newest archived segment has not reached
+// remote storage within WAL_RPO_BUDGET and exports the lag as a metric. Idle
+// segments are closed with pg_switch_wal() after WAL_SWITCH_AFTER.
+func WatchWALArchiving(ctx context.Context, tg *telegram.Service) {
+	budget, err := parseDuration(config.Env("WAL_RPO_BUDGET", "10m"))
+	if err != nil || budget <= 0 {
+		log.Printf("⚠️ [WALSYNC] Invalid WAL_RPO_BUDGET (%v), using 10m", err)
+		budget = 10 * time.Minute
+	}
+	switchAfter, err := parseDuration(config.Env("WAL_SWITCH_AFTER", "5m"))
+	if err != nil {
+		log.Printf("⚠️ [WALSYNC] Invalid WAL_SWITCH_AFTER (%v), using half the budget", err)
+	}
+	if switchAfter <= 0 || switchAfter >= budget {
+		switchAfter = budget / 2
+	}
+	alerts := newAlerter(tg, 30*time.Minute)
+	var switched time.Time // first forced switch not yet followed by an archived segment
+	p := project.From(ctx)
+	log.Printf("🔍 [WALSYNC] WAL archive reconciler started for %s (RPO budget %s, switch after %s)", p.Name, budget, switchAfter)
+
+	ticker := time.NewTicker(2 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		st, err := ReconcileWAL(checkCtx)
+		if err == nil {
+			enforceWALFreshness(checkCtx, st, alerts, &switched, switchAfter, budget)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("⚠️ [WALSYNC] Reconcile failed: %v", err)
+			continue
+		}
+
+		metrics.WALArchiveLag.WithLabelValues(p.Name).Set(st.Lag.Seconds())
+		metrics.WALLocalBacklog.WithLabelValues(p.Name).Set(float64(st.LocalBacklog))
+		if !st.OldestLocal.IsZero() {
+			metrics.WALLocalBacklogAge.WithLabelValues(p.Name).Set(time.Since(st.OldestLocal).Seconds())
+		} else {
+			metrics.WALLocalBacklogAge.WithLabelValues(p.Name).Set(0)
+		}
+		if !st.LastArchivedTime.IsZero() {
+			metrics.WALLastArchived.WithLabelValues(p.Name).Set(float64(st.LastArchivedTime.Unix()))
+		}
+
+		if st.Lag <= budget {
+			alerts.Resolve("rpo", fmt.Sprintf("✅ [WALSYNC] WAL shipping back within budget (%s reached remote)", st.LastArchivedWAL))
+			continue
+		}
+
+		where := "still waiting in " + p.WALDir
+		if !st.InLocal && !st.InRemote {
+			where = "missing from both " + p.WALDir + " and remote storage"
+		}
+		alerts.Fire("rpo", fmt.Sprintf("🚨 [WALSYNC] WAL shipping behind by %s (budget %s)\n• Last archived: %s (%s)\n• Segment %s\n• Local backlog: %d files",
+			st.Lag.Round(time.Second), budget, st.LastArchivedWAL, st.LastArchivedTime.Format("15:04:05"), where, st.LocalBacklog))
+	}
+}
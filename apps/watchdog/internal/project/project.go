@@ -0,0 +1,382 @@
+// Package project describes the Supabase stacks one watchdog looks after.
+// The "main" project is the historical one (supabase-db, /wal_archive,
+// dropbox:SupabaseServerBackups); projects.json adds others such as dev.
+package project
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/db"
+	"github.com/GoldenCarrotMLP/watchdog/internal/schedule"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// DefaultName is the project used when a request or job names none.
+const DefaultName = "main"
+
+// Project is one entry of projects.json.
+type Project struct {
+	Name        string `json:"name"`
+	DBContainer string `json:"db_container"` // container pg_dump/psql run in
+	DBUser      string `json:"db_user"`
+	DatabaseURL string `json:"database_url"` // direct connection for monitors and checks; main defaults to DATABASE_URL
+	Remote      string `json:"remote"`       // rclone remote, e.g. "dropbox:"; the primary destination's when destinations are set
+	Prefix      string `json:"prefix"`       // folder under the remote, e.g. "dev/"
+	// Destinations are the remotes every artifact is written to, one of them
+	// primary. Without them the project has Remote as its only destination.
+	Destinations []Destination `json:"destinations"`
+	// WALDir is where this project's archive_command drops WAL segments.
+	// Empty disables PITR: no WAL uploads, base backups or archiving.
+	WALDir         string             `json:"wal_dir"`
+	StateDir       string             `json:"state_dir"` // local state, default /app/pitr/<name>
+	TelegramChatID string             `json:"telegram_chat_id"`
+	Schedules      []schedule.JobSpec `json:"schedules"` // overrides of the defaults; "off" drops a job
+	// ReplicationSecret is the file holding the replication role's password:
+	// REPLICATION_PASSWORD_FILE for main, /run/secrets/replication_password_<name>
+	// for the others.
+	ReplicationSecret string `json:"replication_secret"`
+
+	tg *notifier
+}
+
+// notifier is shared by a project and its copies from On.
+type notifier struct {
+	once sync.Once
+	svc  *telegram.Service
+}
+
+// Destination roles: the primary takes the uploads and serves reads,
+// secondaries are the first fallback, cold copies the last resort.
+const (
+	RolePrimary   = "primary"
+	RoleSecondary = "secondary"
+	RoleCold      = "cold"
+)
+
+// Destination is one rclone remote a project's backups are replicated to.
+type Destination struct {
+	Name   string `json:"name"`
+	Remote string `json:"remote"` // rclone remote or path in the rclone container, e.g. "b2:watchdog-backups/"
+	Role   string `json:"role"`
+}
+
+var roleOrder = map[string]int{RolePrimary: 0, RoleSecondary: 1, RoleCold: 2}
+
+type File struct {
+	Projects []*Project `json:"projects"`
+}
+
+var (
+	mu       sync.RWMutex
+	projects = []*Project{defaultProject()}
+)
+
+var nameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func defaultProject() *Project {
+	p := &Project{
+		Name:        DefaultName,
+		DBContainer: "supabase-db",
+		DBUser:      "supabase_admin",
+		Remote:      "dropbox:",
+		WALDir:      "/wal_archive",
+		StateDir:    "/app/pitr",
+		tg:          &notifier{},
+
+		ReplicationSecret: config.Env("REPLICATION_PASSWORD_FILE", "/run/secrets/replication_password"),
+	}
+	p.destinations()
+	return p
+}
+
+// Load reads PROJECTS_FILE (default <config dir>/projects.json). The main
+// project always exists; an entry named "main" only overrides its fields.
+// On error the registry keeps the main project alone.
+func Load() ([]*Project, error) {
+	var f File
+	if _, err := config.Load(config.Env("PROJECTS_FILE", config.Path("projects.json")), &f); err != nil {
+		return All(), err
+	}
+
+	main := defaultProject()
+	list := []*Project{main}
+	seen := map[string]bool{}
+	for _, p := range f.Projects {
+		if !nameRe.MatchString(p.Name) {
+			return All(), fmt.Errorf("invalid project name %q", p.Name)
+		}
+		if seen[p.Name] {
+			return All(), fmt.Errorf("project %q listed twice", p.Name)
+		}
+		seen[p.Name] = true
+
+		if p.Name == DefaultName {
+			main.merge(p)
+			p = main
+		} else {
+			if p.DBContainer == "" {
+				return All(), fmt.Errorf("project %s: db_container is required", p.Name)
+			}
+			p.defaults()
+			list = append(list, p)
+		}
+		if p.IsDefault() && len(p.Schedules) == 0 {
+			continue // main follows schedules.json
+		}
+		specs, err := schedule.Merge(p.Schedules)
+		if err != nil {
+			return All(), fmt.Errorf("project %s: %w", p.Name, err)
+		}
+		p.Schedules = specs
+	}
+	for _, p := range list {
+		if err := p.destinations(); err != nil {
+			return All(), fmt.Errorf("project %s: %w", p.Name, err)
+		}
+	}
+
+	mu.Lock()
+	projects = list
+	mu.Unlock()
+	for _, p := range list {
+		os.MkdirAll(p.StateDir, 0755)
+		log.Printf("🗂️ [PROJECT] %s: db=%s remote=%s pitr=%v replicas=%d", p.Name, p.DBContainer, p.SnapshotRemote(), p.PITR(), len(p.Mirrors()))
+	}
+	return list, nil
+}
+
+func (p *Project) defaults() {
+	if p.DBUser == "" {
+		p.DBUser = "supabase_admin"
+	}
+	if p.Remote == "" {
+		p.Remote = "dropbox:"
+	}
+	if p.Prefix == "" {
+		p.Prefix = p.Name + "/"
+	}
+	if p.StateDir == "" {
+		p.StateDir = filepath.Join("/app/pitr", p.Name)
+	}
+	if p.ReplicationSecret == "" {
+		p.ReplicationSecret = "/run/secrets/replication_password_" + p.Name
+	}
+	p.tg = &notifier{}
+}
+
+// destinations checks the destination list and points Remote at the primary.
+// Without a list, Remote is the single primary destination.
+func (p *Project) destinations() error {
+	if len(p.Destinations) == 0 {
+		p.Destinations = []Destination{{Name: RolePrimary, Remote: p.Remote, Role: RolePrimary}}
+		return nil
+	}
+	primaries := 0
+	seen := map[string]bool{}
+	for _, d := range p.Destinations {
+		if _, ok := roleOrder[d.Role]; !ok {
+			return fmt.Errorf("destination %q: role must be primary, secondary or cold", d.Name)
+		}
+		if !nameRe.MatchString(d.Name) || seen[d.Name] {
+			return fmt.Errorf("destination name %q is invalid or listed twice", d.Name)
+		}
+		if d.Remote == "" {
+			return fmt.Errorf("destination %s has no remote", d.Name)
+		}
+		seen[d.Name] = true
+		if d.Role == RolePrimary {
+			primaries++
+			p.Remote = d.Remote
+		}
+	}
+	if primaries != 1 {
+		return fmt.Errorf("needs exactly one primary destination, has %d", primaries)
+	}
+	sort.SliceStable(p.Destinations, func(i, j int) bool {
+		return roleOrder[p.Destinations[i].Role] < roleOrder[p.Destinations[j].Role]
+	})
+	return nil
+}
+
+// merge copies the fields set in o onto the main project.
+func (p *Project) merge(o *Project) {
+	for dst, src := range map[*string]string{
+		&p.DBContainer: o.DBContainer, &p.DBUser: o.DBUser, &p.DatabaseURL: o.DatabaseURL,
+		&p.Remote: o.Remote, &p.Prefix: o.Prefix, &p.WALDir: o.WALDir, &p.StateDir: o.StateDir,
+		&p.TelegramChatID: o.TelegramChatID, &p.ReplicationSecret: o.ReplicationSecret,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+	p.Schedules = o.Schedules
+	p.Destinations = o.Destinations
+}
+
+// All returns every project, main first.
+func All() []*Project {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]*Project(nil), projects...)
+}
+
+// Get looks a project up by name; an empty name is the main project.
+func Get(name string) (*Project, error) {
+	if name == "" {
+		name = DefaultName
+	}
+	for _, p := range All() {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown project %q", name)
+}
+
+// Info is what projects.list returns; database urls stay private.
+type Info struct {
+	Name           string             `json:"name"`
+	DBContainer    string             `json:"db_container"`
+	SnapshotRemote string             `json:"snapshot_remote"`
+	WALRemote      string             `json:"wal_remote,omitempty"`
+	PITR           bool               `json:"pitr"`
+	Destinations   []Destination      `json:"destinations"`
+	Schedules      []schedule.JobSpec `json:"schedules,omitempty"`
+}
+
+// Infos describes every project.
+func Infos() []Info {
+	var out []Info
+	for _, p := range All() {
+		i := Info{Name: p.Name, DBContainer: p.DBContainer, SnapshotRemote: p.SnapshotRemote(), PITR: p.PITR(),
+			Destinations: p.Destinations, Schedules: p.Schedules}
+		if p.PITR() {
+			i.WALRemote = p.WALRemote()
+		}
+		out = append(out, i)
+	}
+	return out
+}
+
+// Default returns the main project.
+func Default() *Project { return All()[0] }
+
+type ctxKey struct{}
+
+// With tags ctx with the project tasks started from it work on, and points
+// db.Pool at the project's database. Projects other than main without
+// database_url have no direct connection; the SQL based checks skip them.
+func With(ctx context.Context, p *Project) context.Context {
+	if p.DatabaseURL != "" || !p.IsDefault() {
+		ctx = db.WithURL(ctx, p.DatabaseURL)
+	}
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// From returns the project ctx was tagged with, or the main project.
+func From(ctx context.Context) *Project {
+	if p, ok := ctx.Value(ctxKey{}).(*Project); ok {
+		return p
+	}
+	return Default()
+}
+
+// IsDefault reports whether p is the main project.
+func (p *Project) IsDefault() bool { return p.Name == DefaultName }
+
+// PITR reports whether WAL archiving and base backups run for p.
+func (p *Project) PITR() bool { return p.WALDir != "" }
+
+// HasDatabase reports whether p has a direct connection for SQL checks.
+func (p *Project) HasDatabase() bool { return p.IsDefault() || p.DatabaseURL != "" }
+
+// SnapshotRemote is the rclone path of the logical snapshot folders.
+func (p *Project) SnapshotRemote(parts ...string) string {
+	return p.Remote + path.Join(append([]string{p.Prefix, "SupabaseServerBackups"}, parts...)...)
+}
+
+// WALRemote is the rclone path of the PITR folders (base backups and WAL).
+func (p *Project) WALRemote(parts ...string) string {
+	return p.Remote + path.Join(append([]string{p.Prefix, "SupabaseServerBackups_WAL"}, parts...)...)
+}
+
+// Mirrors are the destinations besides the primary, secondaries first.
+func (p *Project) Mirrors() []Destination {
+	var out []Destination
+	for _, d := range p.Destinations {
+		if d.Role != RolePrimary {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// On returns a copy of p whose remote paths point at d instead of the primary.
+func (p *Project) On(d Destination) *Project {
+	c := *p
+	c.Remote = d.Remote
+	return &c
+}
+
+// State returns a path inside p's local state directory.
+func (p *Project) State(name string) string { return filepath.Join(p.StateDir, name) }
+
+// walConfirmedFile holds the newest WAL file name the uploader saw reach the
+// primary. WAL file names sort in archive order.
+const walConfirmedFile = ".wal_confirmed"
+
+// ConfirmWAL records the newest WAL segment among names as uploaded if it is
+// newer than the last record. History and backup label files are ignored.
+func (p *Project) ConfirmWAL(names []string) {
+	newest := p.ConfirmedWAL()
+	for _, n := range names {
+		if len(n) == 24 && strings.Trim(n, "0123456789ABCDEF") == "" {
+			newest = max(newest, n)
+		}
+	}
+	if newest != "" {
+		os.WriteFile(p.State(walConfirmedFile), []byte(newest), 0644)
+	}
+}
+
+// ConfirmedWAL returns the newest WAL file name the uploader confirmed, or "".
+func (p *Project) ConfirmedWAL() string {
+	b, _ := os.ReadFile(p.State(walConfirmedFile))
+	return strings.TrimSpace(string(b))
+}
+
+// Key namespaces lock, job and schedule names: "snapshot" for main,
+// "dev:snapshot" for dev.
+func (p *Project) Key(name string) string {
+	if p.IsDefault() {
+		return name
+	}
+	return p.Name + ":" + name
+}
+
+// Notifier returns p's Telegram service, derived from base on first use:
+// its own chat when telegram_chat_id is set and a "[name]" prefix.
+func (p *Project) Notifier(base *telegram.Service) *telegram.Service {
+	if p.IsDefault() && p.TelegramChatID == "" {
+		return base
+	}
+	p.tg.once.Do(func() {
+		prefix := ""
+		if !p.IsDefault() {
+			prefix = "[" + p.Name + "] "
+		}
+		p.tg.svc = base.For(p.TelegramChatID, prefix)
+		p.tg.svc.StartWorker()
+	})
+	return p.tg.svc
+}
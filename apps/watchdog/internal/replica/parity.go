@@ -0,0 +1,291 @@
+package replica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/metrics"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+)
+
+const reportFile = "replica_parity.json"
+
+// Problem is one file a destination does not hold as it should.
+type Problem struct {
+	Destination string `json:"destination"`
+	Path        string `json:"path"`             // relative to the project's remote, e.g. SupabaseServerBackups/2026-03-02/x.dump
+	Issue       string `json:"issue"`            // missing, size, checksum or stale
+	Source      string `json:"source,omitempty"` // destination the repair copies from
+	Repaired    bool   `json:"repaired"`
+	Error       string `json:"error,omitempty"`
+}
+
+// DestinationParity sums up one destination.
+type DestinationParity struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Files    int    `json:"files"`
+	Problems int    `json:"problems"`
+	Repaired int    `json:"repaired"`
+	Error    string `json:"error,omitempty"` // set when it could not be listed
+}
+
+// ParityReport is the outcome of one Check.
+type ParityReport struct {
+	Project      string              `json:"project"`
+	Checked      time.Time           `json:"checked"`
+	Files        int                 `json:"files"`
+	Destinations []DestinationParity `json:"destinations"`
+	Problems     []Problem           `json:"problems,omitempty"`
+}
+
+// OK reports whether every destination was listed and held every file.
+func (r ParityReport) OK() bool {
+	for _, d := range r.Destinations {
+		if d.Error != "" || d.Problems > d.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+type remoteFile struct {
+	Path   string            `json:"Path"`
+	Size   int64             `json:"Size"`
+	Hashes map[string]string `json:"Hashes"`
+}
+
+// Check lists the snapshot and PITR folders on every destination and
+// compares them file by file: presence, size and, where two remotes expose
+// the same hash type, checksum. The primary is the reference; a file only
+// found on mirrors counts as missing on the primary, except raw WAL of a day
+// the primary already archived, which is stale and deleted from the mirrors.
+// With repair, missing and differing copies are copied from the primary, or
+// from the first mirror holding the file when the primary lacks it.
+func Check(ctx context.Context, p *project.Project, repair bool) (ParityReport, error) {
+	report := ParityReport{Project: p.Name, Checked: time.Now()}
+	roots := []string{p.SnapshotRemote()}
+	if p.PITR() {
+		roots = append(roots, p.WALRemote())
+	}
+
+	listings := make([]map[string]remoteFile, len(p.Destinations))
+	for i, d := range p.Destinations {
+		report.Destinations = append(report.Destinations, DestinationParity{Name: d.Name, Role: d.Role})
+		files, err := listDestination(ctx, p, d, roots)
+		if err != nil {
+			log.Printf("⚠️ [PARITY] %s: cannot list %s: %v", p.Name, d.Name, err)
+			report.Destinations[i].Error = err.Error()
+			continue
+		}
+		listings[i] = files
+		report.Destinations[i].Files = len(files)
+	}
+	if listings[0] == nil && len(p.Destinations) > 1 {
+		log.Printf("⚠️ [PARITY] %s: primary not listed, comparing the mirrors among themselves", p.Name)
+	}
+
+	paths := map[string]bool{}
+	for _, files := range listings {
+		for rel := range files {
+			paths[rel] = true
+		}
+	}
+	report.Files = len(paths)
+
+	for rel := range paths {
+		if listings[0] != nil && staleWAL(rel, listings[0]) {
+			if _, ok := listings[0][rel]; !ok {
+				for i, files := range listings {
+					if _, ok := files[rel]; ok {
+						report.Problems = append(report.Problems, Problem{Destination: p.Destinations[i].Name, Path: rel, Issue: "stale"})
+					}
+				}
+				continue
+			}
+		}
+		src := -1
+		for i, files := range listings {
+			if _, ok := files[rel]; ok {
+				src = i
+				break
+			}
+		}
+		ref := listings[src][rel]
+		for i, files := range listings {
+			if files == nil || i == src {
+				continue
+			}
+			issue := ""
+			if f, ok := files[rel]; !ok {
+				issue = "missing"
+			} else if f.Size != ref.Size {
+				issue = "size"
+			} else if differ(f.Hashes, ref.Hashes) {
+				issue = "checksum"
+			}
+			if issue != "" {
+				report.Problems = append(report.Problems, Problem{Destination: p.Destinations[i].Name, Path: rel, Issue: issue, Source: p.Destinations[src].Name})
+			}
+		}
+	}
+	sort.Slice(report.Problems, func(i, j int) bool { return report.Problems[i].Path < report.Problems[j].Path })
+
+	// Stale WAL is deleted by name, one batch per destination and folder:
+	// late segments the primary still keeps in the same WAL folder stay.
+	stale := map[string][]int{}
+	for i, pr := range report.Problems {
+		if repair && pr.Issue == "stale" {
+			dir := destination(p, pr.Destination).Remote + path.Dir(pr.Path)
+			stale[dir] = append(stale[dir], i)
+		}
+	}
+	for dir, idx := range stale {
+		var names []string
+		for _, i := range idx {
+			names = append(names, path.Base(report.Problems[i].Path))
+		}
+		cmd := exec.CommandContext(ctx, "docker", "exec", "-i", "supabase-rclone", "rclone", "delete", "--files-from-raw", "-", dir)
+		cmd.Stdin = strings.NewReader(strings.Join(names, "\n") + "\n")
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			rclone(ctx, "rmdir", dir)
+		}
+		for _, i := range idx {
+			pr := &report.Problems[i]
+			if err != nil {
+				pr.Error = fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(out)))
+				log.Printf("❌ [PARITY] %s: could not delete stale %s on %s: %s", p.Name, pr.Path, pr.Destination, pr.Error)
+				continue
+			}
+			pr.Repaired = true
+			metrics.ReplicaRepairs.WithLabelValues(p.Name, pr.Destination).Inc()
+		}
+	}
+
+	for i := 0; repair && i < len(report.Problems); i++ {
+		pr := &report.Problems[i]
+		if pr.Issue == "stale" {
+			continue
+		}
+		d := destination(p, pr.Destination)
+		out, err := rclone(ctx, "copyto", destination(p, pr.Source).Remote+pr.Path, d.Remote+pr.Path)
+		if err != nil {
+			pr.Error = fmt.Sprintf("%v: %s", err, strings.TrimSpace(string(out)))
+			log.Printf("❌ [PARITY] %s: could not repair %s on %s: %s", p.Name, pr.Path, d.Name, pr.Error)
+			continue
+		}
+		pr.Repaired = true
+		metrics.ReplicaRepairs.WithLabelValues(p.Name, d.Name).Inc()
+	}
+
+	for i := range report.Destinations {
+		d := &report.Destinations[i]
+		for _, pr := range report.Problems {
+			if pr.Destination == d.Name {
+				d.Problems++
+				if pr.Repaired {
+					d.Repaired++
+				}
+			}
+		}
+		metrics.ReplicaProblems.WithLabelValues(p.Name, d.Name).Set(float64(d.Problems))
+	}
+	metrics.ReplicaLastCheck.WithLabelValues(p.Name).SetToCurrentTime()
+	log.Printf("🔁 [PARITY] %s: %d files, %d problems across %d destinations", p.Name, report.Files, len(report.Problems), len(p.Destinations))
+
+	if data, err := json.MarshalIndent(report, "", "  "); err == nil {
+		os.WriteFile(p.State(reportFile), data, 0644)
+	}
+	if listings[0] == nil {
+		return report, fmt.Errorf("primary %s could not be listed: %s", p.Destinations[0].Name, report.Destinations[0].Error)
+	}
+	return report, nil
+}
+
+// LastReport returns the report of the latest Check, kept in the project's state dir.
+func LastReport(p *project.Project) (ParityReport, error) {
+	var report ParityReport
+	data, err := os.ReadFile(p.State(reportFile))
+	if err != nil {
+		return report, fmt.Errorf("no parity check has run for %s yet", p.Name)
+	}
+	return report, json.Unmarshal(data, &report)
+}
+
+// listDestination returns every file under roots on d, keyed by its path
+// relative to the destination's remote. Partial uploads are left out.
+func listDestination(ctx context.Context, p *project.Project, d project.Destination, roots []string) (map[string]remoteFile, error) {
+	files := map[string]remoteFile{}
+	for _, root := range roots {
+		dir, _ := pathOn(p, d, root)
+		cmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "lsjson", "-R", "--files-only", "--hash", dir)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if strings.Contains(stderr.String(), "directory not found") {
+				continue // nothing replicated there yet
+			}
+			return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		var items []remoteFile
+		if err := json.Unmarshal(out, &items); err != nil {
+			return nil, err
+		}
+		prefix := strings.TrimPrefix(root, p.Remote)
+		for _, it := range items {
+			if strings.HasSuffix(it.Path, ".partial") {
+				continue
+			}
+			it.Path = path.Join(prefix, it.Path)
+			files[it.Path] = it
+		}
+	}
+	return files, nil
+}
+
+// staleWAL reports whether rel is a raw WAL segment of a day whose archive
+// the primary already holds.
+func staleWAL(rel string, primary map[string]remoteFile) bool {
+	dir := path.Dir(rel)
+	if path.Base(dir) != "WAL" {
+		return false
+	}
+	day := path.Dir(dir)
+	for _, name := range []string{"WAL_archive.tar.gz", "WAL_archive.tar.zst"} {
+		if _, ok := primary[path.Join(day, name)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// differ compares the hash types both listings carry; no common type is no
+// evidence of a difference.
+func differ(a, b map[string]string) bool {
+	for kind, h := range a {
+		if other, ok := b[kind]; ok && h != "" && other != "" && h != other {
+			return true
+		}
+	}
+	return false
+}
+
+func destination(p *project.Project, name string) project.Destination {
+	for _, d := range p.Destinations {
+		if d.Name == name {
+			return d
+		}
+	}
+	return project.Destination{Name: name}
+}
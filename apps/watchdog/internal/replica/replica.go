@@ -0,0 +1,148 @@
+// Package replica keeps a project's backups on all of its destinations
+// (3-2-1): uploads go to the primary and are copied to the mirrors, a parity
+// check compares the destinations and repairs them, and reads fall back to a
+// mirror when the primary cannot be reached.
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/metrics"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+)
+
+// probeTTL is how long a reachability probe of a destination is trusted.
+const probeTTL = time.Minute
+
+// Copy replicates remote, a file or folder on the primary, to every mirror.
+// A mirror that fails is logged and counted but not retried: the parity
+// check repairs it. The error lists the mirrors that were missed.
+func Copy(ctx context.Context, p *project.Project, remote string) error {
+	return each(ctx, p, remote, "copy", func(dst string) []string {
+		return []string{"copyto", remote, dst}
+	})
+}
+
+// Upload writes local, a file or folder inside the rclone container, to
+// remote on every mirror. The primary upload stays with the caller.
+func Upload(ctx context.Context, p *project.Project, local, remote string) error {
+	return each(ctx, p, remote, "upload", func(dst string) []string {
+		return []string{"copyto", local, dst}
+	})
+}
+
+// Purge removes remote, a folder the caller already purged on the primary,
+// from every mirror.
+func Purge(ctx context.Context, p *project.Project, remote string) error {
+	return each(ctx, p, remote, "purge", func(dst string) []string {
+		return []string{"purge", dst}
+	})
+}
+
+// Delete removes the named files directly under remote, a folder the caller
+// already cleaned the same way on the primary, from every mirror.
+func Delete(ctx context.Context, p *project.Project, remote string, names []string) error {
+	list := strings.Join(names, "\n") + "\n"
+	var errs []error
+	for _, d := range p.Mirrors() {
+		dst, ok := pathOn(p, d, remote)
+		if !ok {
+			return fmt.Errorf("%s is not on the primary %s", remote, p.Remote)
+		}
+		cmd := exec.CommandContext(ctx, "docker", "exec", "-i", "supabase-rclone", "rclone", "delete", "--files-from-raw", "-", dst)
+		cmd.Stdin = strings.NewReader(list)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			metrics.ReplicationFailures.WithLabelValues(p.Name, d.Name).Inc()
+			log.Printf("⚠️ [REPLICA] delete under %s on %s failed: %v: %s", remote, d.Name, err, strings.TrimSpace(string(out)))
+			errs = append(errs, fmt.Errorf("%s: %w", d.Name, err))
+			continue
+		}
+		rclone(ctx, "rmdir", dst)
+	}
+	return errors.Join(errs...)
+}
+
+func each(ctx context.Context, p *project.Project, remote, op string, args func(dst string) []string) error {
+	var errs []error
+	for _, d := range p.Mirrors() {
+		dst, ok := pathOn(p, d, remote)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s is not on the primary %s", remote, p.Remote))
+			break
+		}
+		out, err := rclone(ctx, args(dst)...)
+		if err != nil {
+			metrics.ReplicationFailures.WithLabelValues(p.Name, d.Name).Inc()
+			log.Printf("⚠️ [REPLICA] %s of %s to %s failed: %v: %s", op, remote, d.Name, err, strings.TrimSpace(string(out)))
+			errs = append(errs, fmt.Errorf("%s: %w", d.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pathOn maps a path on the primary to the same path on destination d.
+func pathOn(p *project.Project, d project.Destination, remote string) (string, bool) {
+	rest, ok := strings.CutPrefix(remote, p.Remote)
+	return d.Remote + rest, ok
+}
+
+func rclone(ctx context.Context, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, "docker", append([]string{"exec", "supabase-rclone", "rclone"}, args...)...).CombinedOutput()
+}
+
+type probe struct {
+	ok bool
+	at time.Time
+}
+
+var (
+	probesMu sync.Mutex
+	probes   = map[string]probe{}
+)
+
+// Reader returns p itself while its primary destination answers, otherwise a
+// copy of p pointing at the first reachable mirror (secondaries before cold).
+// Restores and listings read through it; writes always go to the primary.
+func Reader(ctx context.Context, p *project.Project) *project.Project {
+	if len(p.Mirrors()) == 0 || reachable(ctx, p) {
+		return p
+	}
+	for _, d := range p.Mirrors() {
+		if m := p.On(d); reachable(ctx, m) {
+			metrics.ReplicaFallbacks.WithLabelValues(p.Name, d.Name).Inc()
+			log.Printf("↪️ [REPLICA] %s: primary %s unreachable, reading from %s (%s)", p.Name, p.Remote, d.Name, d.Role)
+			return m
+		}
+	}
+	log.Printf("❌ [REPLICA] %s: no destination reachable, staying on the primary", p.Name)
+	return p
+}
+
+// reachable lists the top of p's folder on its remote, at most once per probeTTL.
+func reachable(ctx context.Context, p *project.Project) bool {
+	root := p.Remote + p.Prefix
+	probesMu.Lock()
+	pr, ok := probes[root]
+	probesMu.Unlock()
+	if ok && time.Since(pr.at) < probeTTL {
+		return pr.ok
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	_, err := rclone(probeCtx, "lsjson", "--dirs-only", "--max-depth", "1", root)
+	if err != nil {
+		log.Printf("⚠️ [REPLICA] %s is unreachable: %v", root, err)
+	}
+	probesMu.Lock()
+	probes[root] = probe{ok: err == nil, at: time.Now()}
+	probesMu.Unlock()
+	return err == nil
+}
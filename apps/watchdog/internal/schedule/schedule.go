@@ -0,0 +1,178 @@
+package schedule
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/robfig/cron/v3"
+)
+
+// JobSpec is one entry of schedules.json. Every spec must start with an
+// explicit CRON_TZ=<zone>, e.g. "CRON_TZ=UTC 1 0 * * *".
+type JobSpec struct {
+	Name string `json:"name"`
+	Spec string `json:"spec"`
+}
+
+type File struct {
+	Jobs []JobSpec `json:"jobs"`
+}
+
+// Defaults match the historical schedule; the container runs in UTC.
+var Defaults = []JobSpec{
+	{Name: "snapshot", Spec: "CRON_TZ=UTC 0,30 14-23 * * 1-5"},
+	{Name: "base_backup", Spec: "CRON_TZ=UTC 1 0 * * *"},
+	{Name: "archive_yesterday", Spec: "CRON_TZ=UTC 5 0 * * *"},
+	{Name: "verify_base", Spec: "CRON_TZ=UTC 30 3 * * 0"},
+	{Name: "recovery_report", Spec: "CRON_TZ=UTC 15 1 * * *"},
+	{Name: "replica_parity", Spec: "CRON_TZ=UTC 30 4 * * *"},
+}
+
+// JobInfo is what schedules.list returns for each job.
+type JobInfo struct {
+	Name        string    `json:"name"`
+	Spec        string    `json:"spec"`
+	Timezone    string    `json:"timezone"`
+	NextRun     time.Time `json:"next_run"`
+	LastStart   time.Time `json:"last_start,omitempty"`
+	LastEnd     time.Time `json:"last_end,omitempty"`
+	LastOutcome string    `json:"last_outcome,omitempty"` // succeeded, failed, running
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type entry struct {
+	info JobInfo
+	id   cron.EntryID
+}
+
+// Scheduler wraps robfig/cron and remembers each job's last outcome.
+type Scheduler struct {
+	cron *cron.Cron
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func New() *Scheduler {
+	return &Scheduler{cron: cron.New(), entries: make(map[string]*entry)}
+}
+
+// Load reads SCHEDULES_FILE (default <config dir>/schedules.json) on top of
+// the defaults and validates every spec. Jobs not listed keep their default.
+func Load() ([]JobSpec, error) {
+	var f File
+	if _, err := config.Load(config.Env("SCHEDULES_FILE", config.Path("schedules.json")), &f); err != nil {
+		return Defaults, err
+	}
+	return Merge(f.Jobs)
+}
+
+// Merge applies overrides to the defaults. A spec of "off" drops the job.
+func Merge(overrides []JobSpec) ([]JobSpec, error) {
+	specs := make(map[string]string)
+	for _, j := range Defaults {
+		specs[j.Name] = j.Spec
+	}
+	for _, j := range overrides {
+		if _, ok := specs[j.Name]; !ok {
+			return Defaults, fmt.Errorf("unknown job %q", j.Name)
+		}
+		specs[j.Name] = j.Spec
+	}
+
+	var out []JobSpec
+	for _, d := range Defaults {
+		s := JobSpec{Name: d.Name, Spec: specs[d.Name]}
+		if s.Spec == "off" {
+			continue
+		}
+		if err := Validate(s.Spec); err != nil {
+			return Defaults, fmt.Errorf("%s: %w", s.Name, err)
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// Validate requires an explicit, loadable CRON_TZ and a parseable 5-field spec.
+func Validate(spec string) error {
+	tz, _ := splitTZ(spec)
+	if tz == "" {
+		return fmt.Errorf("spec %q must start with CRON_TZ=<zone>", spec)
+	}
+	if _, err := time.LoadLocation(tz); err != nil {
+		return fmt.Errorf("unknown timezone %q", tz)
+	}
+	if _, err := cron.ParseStandard(spec); err != nil {
+		return err
+	}
+	return nil
+}
+
+func splitTZ(spec string) (string, string) {
+	if !strings.HasPrefix(spec, "CRON_TZ=") {
+		return "", spec
+	}
+	tz, rest, _ := strings.Cut(strings.TrimPrefix(spec, "CRON_TZ="), " ")
+	return tz, strings.TrimSpace(rest)
+}
+
+// Add registers fn under name with the given spec.
+func (s *Scheduler) Add(name, spec string, fn func() error) error {
+	tz, _ := splitTZ(spec)
+	e := &entry{info: JobInfo{Name: name, Spec: spec, Timezone: tz}}
+
+	id, err := s.cron.AddFunc(spec, func() {
+		s.mu.Lock()
+		e.info.LastStart = time.Now()
+		e.info.LastOutcome = "running"
+		e.info.LastError = ""
+		s.mu.Unlock()
+
+		err := fn()
+
+		s.mu.Lock()
+		e.info.LastEnd = time.Now()
+		e.info.LastOutcome = "succeeded"
+		if err != nil {
+			e.info.LastOutcome = "failed"
+			e.info.LastError = err.Error()
+		}
+		s.mu.Unlock()
+	})
+	if err != nil {
+		return err
+	}
+	e.id = id
+
+	s.mu.Lock()
+	s.entries[name] = e
+	s.mu.Unlock()
+	log.Printf("🗓️ [SCHEDULE] %s: %s", name, spec)
+	return nil
+}
+
+func (s *Scheduler) Start() { s.cron.Start() }
+
+// Stop stops scheduling; the returned channel closes once running jobs finish.
+func (s *Scheduler) Stop() <-chan struct{} { return s.cron.Stop().Done() }
+
+// List returns every job with its next run, sorted by name.
+func (s *Scheduler) List() []JobInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]JobInfo, 0, len(s.entries))
+	for _, e := range s.entries {
+		info := e.info
+		info.NextRun = s.cron.Entry(e.id).Next
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
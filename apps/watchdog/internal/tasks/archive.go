@@ -1,6 +1,7 @@
 package tasks
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,6 +12,8 @@ import (
 	"time"
 
 	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
@@ -21,144 +24,185 @@ type RcloneItem struct {
 	ModTime time.Time `json:"ModTime"`
 }
 
-// MAIN ENTRYPOINT: Startup deep consistency check
-func RunStartupBackfill(tg *telegram.Service) {
-	log.Println("🕵️ [BACKFILL] Starting deep consistency check on Dropbox...")
+// MAIN ENTRYPOINT: Scheduled as "archive_yesterday" (see schedules.json)
+func RunArchiveYesterday(ctx context.Context, tg *telegram.Service) error {
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	return ArchiveRemoteDay(ctx, yesterday, tg)
+}
 
-	cmd := exec.Command("docker", "exec", "supabase-rclone", "rclone",
-		"lsf", "--dirs-only", "dropbox:SupabaseServerBackups_WAL/")
-	out, err := cmd.Output()
+// CORE LOGIC: Standard Archive Flow
+func ArchiveRemoteDay(ctx context.Context, date string, tg *telegram.Service) (err error) {
+	if err := archivableDay(date); err != nil {
+		return err
+	}
+	ctx, finish, err := begin(ctx, tg, "archive_"+date)
 	if err != nil {
-		log.Printf("⚠️ [BACKFILL] Dropbox connection failed: %v", err)
-		return
+		return err
 	}
+	defer func() { finish(err == nil) }()
 
-	today := time.Now().Format("2006-01-02")
-	dates := strings.Split(strings.TrimSpace(string(out)), "\n")
+	p := project.From(ctx)
+	remoteRoot := p.WALRemote(date)
+	localMeta := localMetaPath(ctx, date)
 
-	for _, dateDir := range dates {
-		date := strings.TrimSuffix(dateDir, "/")
-		if date == "" || date == today {
-			continue
-		}
-
-		lsCmd := exec.Command("docker", "exec", "supabase-rclone", "rclone",
-			"lsjson", fmt.Sprintf("dropbox:SupabaseServerBackups_WAL/%s/", date))
-		jsonOut, _ := lsCmd.Output()
+	// Fetch base timestamp if not already known
+	var baseTime time.Time
+	lsBaseCmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "lsjson", remoteRoot+"/base.tar.gz")
+	if out, err := lsBaseCmd.Output(); err == nil {
 		var items []RcloneItem
-		json.Unmarshal(jsonOut, &items)
-
-		hasArchive, hasMetadata, hasWalDir, hasBase := false, false, false, false
-		var baseTime time.Time
-
-		for _, item := range items {
-			if item.Name == "WAL_archive.tar.gz" { hasArchive = true }
-			if item.Name == "metadata.json" { hasMetadata = true }
-			if item.Name == "base.tar.gz" { 
-				hasBase = true 
-				baseTime = item.ModTime
-			}
-			if item.Name == "WAL" && item.IsDir { hasWalDir = true }
+		if err := json.Unmarshal(out, &items); err == nil && len(items) > 0 {
+			baseTime = items[0].ModTime
 		}
+	}
 
-		// 0. Perfectly Healthy
-		if hasArchive && hasMetadata && hasBase {
-			log.Printf("✅ [BACKFILL] %s is healthy.", date)
-			continue
-		}
+	segs, err := listRemote(ctx, remoteRoot+"/WAL")
+	if err != nil {
+		archiveFailed(tg, date, err)
+		return err
+	}
 
-		// 1. Missing Metadata, but Archive Exists (Manual Deletion Case)
-		if hasArchive && !hasMetadata {
-			log.Printf("🔧 [HEAL] %s: Archive exists, metadata missing. Regenerating...", date)
-			HealMetadataFromArchive(date, baseTime, tg)
-			continue
+	// Stream segments into the archive; nothing lands on local disk
+	log.Printf("📦 [ARCHIVE] Streaming %d WAL segments for %s into %s...", len(segs), date, walArchiveName(WALCompression))
+	archive, err := buildWALArchive(ctx, remoteRoot, segs)
+	if err != nil {
+		if ctx.Err() != nil {
+			log.Printf("⏸️ [ARCHIVE] %s interrupted, will resume on next start", date)
+			return err
 		}
+		archiveFailed(tg, date, err)
+		return err
+	}
+	logArchive(date, archive)
 
-		// 2. No Archive, No Metadata, but RAW WALs exist (Standard Backfill)
-		if !hasArchive && !hasMetadata && hasWalDir {
-			log.Printf("🧹 [HEAL] %s: Raw WALs found, starting archive process...", date)
-			ArchiveRemoteDay(date, tg)
-			continue
-		}
+	// Compute and Save
+	meta, err := scanAndUpload(ctx, date, walMapFrom(segs, date), localMeta, remoteRoot, baseTime, &archive)
+	if err == nil {
+		err = checkArchivedSegments(ctx, remoteRoot+"/"+archive.Name, segs)
+	}
+	if err != nil {
+		log.Printf("⚠️ [ARCHIVE] Raw WALs for %s kept: %v", date, err)
+		tg.Send(fmt.Sprintf("⚠️ WAL archive for %s uploaded, raw WALs kept: %v", date, err))
+		replica.Copy(ctx, p, remoteRoot)
+		return err
+	}
 
-		// 3. Completely Empty / No WALs / No Archive (Data Loss Case)
-		if !hasArchive && !hasMetadata && !hasWalDir {
-			log.Printf("🚨 [HEAL] %s: DATA LOSS DETECTED.", date)
-			HandleTotalDataLoss(date, tg)
-		}
+	// Raw WALs go only once the archive is re-read, the metadata points at it,
+	// and on a replica only once it holds the archive as well. Only the
+	// archived names are deleted: a segment uploaded late stays in WAL/.
+	names := make([]string, len(segs))
+	for i, s := range segs {
+		names[i] = s.Name
+	}
+	if err := deleteRemoteFiles(ctx, remoteRoot+"/WAL", names); err != nil {
+		log.Printf("⚠️ [ARCHIVE] Could not delete raw WALs for %s (kept, archive is complete): %v", date, err)
+		replica.Copy(ctx, p, remoteRoot)
+	} else if replica.Copy(ctx, p, remoteRoot) == nil {
+		replica.Delete(ctx, p, remoteRoot+"/WAL", names)
 	}
-}
 
-// MAIN ENTRYPOINT: Scheduled Cron at 23:59
-func RunArchiveYesterday(tg *telegram.Service) {
-	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
-	ArchiveRemoteDay(yesterday, tg)
+	notifySuccess(tg, date, meta)
+	return nil
 }
 
-// CORE LOGIC: Standard Archive Flow
-func ArchiveRemoteDay(date string, tg *telegram.Service) {
-	remoteRoot := fmt.Sprintf("dropbox:SupabaseServerBackups_WAL/%s", date)
-	localBase := fmt.Sprintf("/app/pitr/supabase-%s_base", date)
-	localWalDir := filepath.Join(localBase, "WAL")
-	localArchive := filepath.Join(localBase, "WAL_archive.tar.gz")
-	localMeta := filepath.Join(localBase, "metadata.json")
-
-	os.RemoveAll(localBase)
-	os.MkdirAll(localBase, 0755)
+// archivableDay rejects days that are not over yet: WAL still arrives for
+// them and would be deleted without being in the archive.
+func archivableDay(date string) error {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		return fmt.Errorf("invalid date %q", date)
+	}
+	if date >= time.Now().Format("2006-01-02") {
+		return fmt.Errorf("%s is not over yet, only past days can be archived", date)
+	}
+	return nil
+}
 
-	// Fetch base timestamp if not already known
-	var baseTime time.Time
-	lsBaseCmd := exec.Command("docker", "exec", "supabase-rclone", "rclone", "lsjson", remoteRoot+"/base.tar.gz")
-	if out, err := lsBaseCmd.Output(); err == nil {
-		var items []RcloneItem
-		if err := json.Unmarshal(out, &items); err == nil && len(items) > 0 {
-			baseTime = items[0].ModTime
+// checkArchivedSegments reads the published archive back and checks that it
+// holds every listed segment before the raw copies are deleted.
+func checkArchivedSegments(ctx context.Context, remote string, segs []RcloneItem) error {
+	walMap, info, err := readWALArchive(ctx, remote)
+	if err != nil {
+		return fmt.Errorf("re-read archive: %w", err)
+	}
+	if info.Segments != len(segs) {
+		return fmt.Errorf("archive holds %d files, %d listed", info.Segments, len(segs))
+	}
+	for _, s := range segs {
+		if _, ok := walMap[s.Name]; !ok && len(s.Name) >= 24 {
+			return fmt.Errorf("segment %s is missing from the archive", s.Name)
 		}
 	}
+	return nil
+}
 
-	log.Printf("⬇️ [ARCHIVE] Downloading WALs for %s...", date)
-	exec.Command("docker", "exec", "supabase-rclone", "rclone", "copy", remoteRoot+"/WAL", localWalDir).Run()
-
-	// Compute and Save
-	meta := scanAndUpload(date, localWalDir, localMeta, remoteRoot, baseTime, tg)
-
-	log.Printf("📦 [ARCHIVE] Compressing...")
-	if err := exec.Command("tar", "-czf", localArchive, "-C", localBase, "WAL").Run(); err == nil {
-		exec.Command("docker", "exec", "supabase-rclone", "rclone", "copyto", localArchive, remoteRoot+"/WAL_archive.tar.gz").Run()
-		exec.Command("docker", "exec", "supabase-rclone", "rclone", "purge", remoteRoot+"/WAL").Run()
+// deleteRemoteFiles deletes the named files directly under remote and the
+// folder itself once it is empty.
+func deleteRemoteFiles(ctx context.Context, remote string, names []string) error {
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-i", "supabase-rclone", "rclone",
+		"delete", "--files-from-raw", "-", remote)
+	cmd.Stdin = strings.NewReader(strings.Join(names, "\n") + "\n")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("rclone delete: %w: %s", err, lastLines(string(out), 3))
 	}
+	exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "rmdir", remote).Run()
+	return nil
+}
 
-	os.RemoveAll(localWalDir)
-	os.Remove(localArchive)
+// localMetaPath is where a day's metadata.json is kept in the project's state dir.
+func localMetaPath(ctx context.Context, date string) string {
+	return project.From(ctx).State(fmt.Sprintf("supabase-%s_base/metadata.json", date))
+}
 
-	notifySuccess(tg, date, meta)
+// archiveFailed reports a day whose raw WALs were left untouched.
+func archiveFailed(tg *telegram.Service, date string, err error) {
+	log.Printf("❌ [ARCHIVE] %s failed: %v", date, err)
+	tg.Send(fmt.Sprintf("🚨 *WAL archive for %s failed*\n%v\nRaw WALs were left untouched; retrying on next start.", date, err))
 }
 
 // HEALING LOGIC: Metadata Recovery
-func HealMetadataFromArchive(date string, baseTime time.Time, tg *telegram.Service) {
-	remoteRoot := fmt.Sprintf("dropbox:SupabaseServerBackups_WAL/%s", date)
-	localBase := fmt.Sprintf("/app/pitr/supabase-%s_base", date)
-	localWalDir := filepath.Join(localBase, "WAL")
-	localArchive := filepath.Join(localBase, "WAL_archive.tar.gz")
-	localMeta := filepath.Join(localBase, "metadata.json")
-
-	os.MkdirAll(localBase, 0755)
-	log.Printf("⬇️ [HEAL] Downloading archive for %s to regenerate metadata...", date)
-	exec.Command("docker", "exec", "supabase-rclone", "rclone", "copyto", remoteRoot+"/WAL_archive.tar.gz", localArchive).Run()
-	exec.Command("tar", "-xzf", localArchive, "-C", localBase).Run()
-	os.Remove(localArchive)
-
-	meta := scanAndUpload(date, localWalDir, localMeta, remoteRoot, baseTime, tg)
-	os.RemoveAll(localWalDir)
+func HealMetadataFromArchive(ctx context.Context, date string, baseTime time.Time, tg *telegram.Service) error {
+	remoteRoot := project.From(ctx).WALRemote(date)
+	localMeta := localMetaPath(ctx, date)
+
+	items, err := listRemote(ctx, remoteRoot)
+	if err != nil {
+		log.Printf("⚠️ [HEAL] %v", err)
+		return err
+	}
+	name := ""
+	for _, it := range items {
+		if isWALArchive(it.Name) {
+			name = it.Name
+		}
+	}
+	if name == "" {
+		log.Printf("⚠️ [HEAL] No WAL archive for %s", date)
+		return fmt.Errorf("no WAL archive for %s", date)
+	}
+
+	log.Printf("⬇️ [HEAL] Reading %s for %s to regenerate metadata...", name, date)
+	walMap, archive, err := readWALArchive(ctx, remoteRoot+"/"+name)
+	if err != nil {
+		log.Printf("❌ [HEAL] %s unreadable: %v", name, err)
+		tg.Send(fmt.Sprintf("🚨 WAL archive %s/%s is unreadable: %v", date, name, err))
+		return err
+	}
+
+	meta, err := scanAndUpload(ctx, date, walMap, localMeta, remoteRoot, baseTime, &archive)
+	if err != nil {
+		log.Printf("⚠️ [HEAL] %v", err)
+		return err
+	}
+	replica.Copy(ctx, project.From(ctx), remoteRoot+"/metadata.json")
 
 	tg.Send(fmt.Sprintf("🩹 Metadata consistency restored for %s (extracted from archive).", date))
 	notifySuccess(tg, date, meta)
+	return nil
 }
 
 // DATA LOSS LOGIC
-func HandleTotalDataLoss(date string, tg *telegram.Service) {
-	localMeta := fmt.Sprintf("/app/pitr/supabase-%s_base/metadata.json", date)
-	remoteRoot := fmt.Sprintf("dropbox:SupabaseServerBackups_WAL/%s", date)
+func HandleTotalDataLoss(ctx context.Context, date string, tg *telegram.Service) {
+	localMeta := localMetaPath(ctx, date)
+	remoteRoot := project.From(ctx).WALRemote(date)
 
 	tg.Send(fmt.Sprintf("🛑 *CRITICAL DATA LOSS:* No WALs or archives found for %s on Dropbox!", date))
 
@@ -168,29 +212,14 @@ func HandleTotalDataLoss(date string, tg *telegram.Service) {
 		MissingSegments: []string{"TOTAL_DATA_LOSS_ON_STORAGE"},
 		IsArchived:      false,
 	}
-	saveAndUploadMetadata(date, localMeta, remoteRoot, fakeMeta)
+	saveAndUploadMetadata(ctx, date, localMeta, remoteRoot, fakeMeta)
 }
 
-// SHARED HELPER: Scan local files and push metadata
-func scanAndUpload(date string, localWalDir, localMeta, remoteRoot string, baseTime time.Time, tg *telegram.Service) api.PitrMetadata {
-	entries, _ := os.ReadDir(localWalDir)
-	walMap := make(map[string]time.Time)
-	
+// SHARED HELPER: Compute and push metadata for a day's segments
+func scanAndUpload(ctx context.Context, date string, walMap map[string]time.Time, localMeta, remoteRoot string, baseTime time.Time, archive *api.WALArchive) (api.PitrMetadata, error) {
 	// Fallback time based on the date string (UTC Midnight)
 	fallbackTime, _ := time.Parse("2006-01-02", date)
 
-	for _, f := range entries {
-		if len(f.Name()) >= 24 {
-			info, err := f.Info()
-			if err == nil && info.ModTime().Year() > 2000 {
-				walMap[f.Name()] = info.ModTime()
-			} else {
-				// If we can't get a real time, use the fallback
-				walMap[f.Name()] = fallbackTime
-			}
-		}
-	}
-
 	// Calculate continuity
 	metadata := api.CalculateContinuity(date, walMap, baseTime)
 	
@@ -204,22 +233,32 @@ func scanAndUpload(date string, localWalDir, localMeta, remoteRoot string, baseT
 
 	metadata.IsArchived = true
 	metadata.BaseBackup = "base.tar.gz"
+	metadata.WALArchive = archive
+	metadata.BackupType = "full"
+	metadata.BaseChain = []string{date}
+	if info, err := fetchBaseInfo(ctx, remoteRoot); err == nil {
+		metadata.BackupType = info.Type
+		metadata.BaseChain = info.Chain
+		metadata.BaseVerification = info.Verification
+	}
 
 	// Save and Upload
 	os.MkdirAll(filepath.Dir(localMeta), 0755)
 	metaJson, _ := json.MarshalIndent(metadata, "", "  ")
 	os.WriteFile(localMeta, metaJson, 0644)
-	exec.Command("docker", "exec", "supabase-rclone", "rclone", "copyto", localMeta, remoteRoot+"/metadata.json").Run()
-	
-	return metadata
+	if out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "copyto", localMeta, remoteRoot+"/metadata.json").CombinedOutput(); err != nil {
+		return metadata, fmt.Errorf("upload metadata for %s: %w: %s", date, err, out)
+	}
+	return metadata, nil
 }
 
 
-func saveAndUploadMetadata(date, localPath, remoteRoot string, meta api.PitrMetadata) {
+func saveAndUploadMetadata(ctx context.Context, date, localPath, remoteRoot string, meta api.PitrMetadata) {
 	os.MkdirAll(filepath.Dir(localPath), 0755)
 	metaJson, _ := json.MarshalIndent(meta, "", "  ")
 	os.WriteFile(localPath, metaJson, 0644)
-	exec.Command("docker", "exec", "supabase-rclone", "rclone", "copyto", localPath, remoteRoot+"/metadata.json").Run()
+	exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "copyto", localPath, remoteRoot+"/metadata.json").Run()
+	replica.Upload(ctx, project.From(ctx), localPath, remoteRoot+"/metadata.json")
 }
 
 func notifySuccess(tg *telegram.Service, date string, meta api.PitrMetadata) {
@@ -0,0 +1,394 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/lock"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// DayState is what a PITR day folder looks like on remote storage.
+type DayState string
+
+const (
+	StateHealthy       DayState = "healthy"        // base, verified archive and continuous metadata
+	StateNeedsArchive  DayState = "needs_archive"  // raw WAL/ not archived yet
+	StateNeedsMetadata DayState = "needs_metadata" // archive without metadata.json
+	StateStaleWAL      DayState = "stale_wal"      // archive exists next to raw WAL/: not purged, or late segments
+	StateNoBase        DayState = "no_base"        // WAL but no base backup for the day
+	StateGaps          DayState = "gaps"           // metadata reports missing segments
+	StateBadBase       DayState = "bad_base"       // base backup failed verification
+	StateBaseOnly      DayState = "base_only"      // base backup but no WAL at all
+	StateLost          DayState = "lost"           // nothing left for the day
+)
+
+// Outcome is the result of one backfill pass over a day.
+type Outcome string
+
+const (
+	OutcomeHealthy  Outcome = "healthy"
+	OutcomeRepaired Outcome = "repaired"
+	OutcomeDegraded Outcome = "degraded"
+	OutcomeLost     Outcome = "lost"
+	OutcomeFailed   Outcome = "failed"
+)
+
+type dayScan struct {
+	hasArchive, hasMeta, hasWAL, hasBase bool
+	baseTime                             time.Time
+	walCount                             int
+	meta                                 *api.PitrMetadata
+}
+
+type dayResult struct {
+	State   DayState  `json:"state"`           // as found
+	Final   DayState  `json:"final,omitempty"` // after repair
+	Outcome Outcome   `json:"outcome"`
+	Detail  string    `json:"detail,omitempty"`
+	At      time.Time `json:"at"`
+}
+
+// backfillRun is persisted after every day so a restart picks up where the
+// previous run stopped instead of rescanning everything.
+type backfillRun struct {
+	Started  time.Time            `json:"started"`
+	Finished time.Time            `json:"finished,omitzero"`
+	Days     map[string]dayResult `json:"days"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// backfillStateFile lives in the project's state dir.
+const backfillStateFile = ".backfill_state.json"
+
+// BackfillConcurrency bounds how many days are repaired at once (BACKFILL_CONCURRENCY).
+var BackfillConcurrency = func() int {
+	if n, err := strconv.Atoi(config.Env("BACKFILL_CONCURRENCY", "3")); err == nil && n > 0 {
+		return n
+	}
+	return 3
+}()
+
+// MAIN ENTRYPOINT: Startup deep consistency check
+func RunStartupBackfill(ctx context.Context, tg *telegram.Service) error {
+	p := project.From(ctx)
+	release, err := lock.Acquire(ctx, p.Key("backfill"), lock.Skip)
+	if err != nil {
+		log.Printf("⏭️ [BACKFILL] %v", err)
+		return err
+	}
+	defer release()
+
+	log.Println("🕵️ [BACKFILL] Starting deep consistency check on Dropbox...")
+
+	cmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone",
+		"lsf", "--dirs-only", p.WALRemote())
+	out, err := cmd.Output()
+	if err != nil {
+		log.Printf("⚠️ [BACKFILL] Dropbox connection failed: %v", err)
+		return err
+	}
+
+	today := time.Now().Format("2006-01-02")
+	var dates []string
+	for _, d := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if d = strings.TrimSuffix(d, "/"); d != "" && d != today {
+			dates = append(dates, d)
+		}
+	}
+
+	run := loadBackfillRun(p.State(backfillStateFile))
+	pending := dates[:0:0]
+	for _, d := range dates {
+		if r, ok := run.Days[d]; ok && r.Outcome != OutcomeFailed {
+			continue
+		}
+		pending = append(pending, d)
+	}
+	if len(pending) < len(dates) {
+		log.Printf("♻️ [BACKFILL] Resuming run from %s: %d of %d days left", run.Started.Format(time.RFC3339), len(pending), len(dates))
+	}
+
+	sem := make(chan struct{}, BackfillConcurrency)
+	var wg sync.WaitGroup
+	for _, date := range pending {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(date string) {
+			defer func() { <-sem; wg.Done() }()
+			res := backfillDay(ctx, date, tg)
+			if ctx.Err() != nil && res.Outcome == OutcomeFailed {
+				return // interrupted, not failed: retry on resume
+			}
+			run.record(date, res)
+		}(date)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Println("🛑 [BACKFILL] Stopped by shutdown, will resume on next start")
+		return ctx.Err()
+	}
+
+	run.mu.Lock()
+	run.Finished = time.Now()
+	run.mu.Unlock()
+	run.save()
+
+	tg.Send(formatBackfillSummary(run, dates))
+	return nil
+}
+
+func backfillDay(ctx context.Context, date string, tg *telegram.Service) dayResult {
+	scan, err := scanDay(ctx, date)
+	if err != nil {
+		return dayResult{Outcome: OutcomeFailed, Detail: err.Error(), At: time.Now()}
+	}
+	state := classifyDay(scan)
+	res := dayResult{State: state, At: time.Now()}
+
+	var repairErr error
+	switch state {
+	case StateHealthy:
+		log.Printf("✅ [BACKFILL] %s is healthy.", date)
+		res.Outcome = OutcomeHealthy
+		return res
+	case StateNeedsArchive:
+		log.Printf("🧹 [HEAL] %s: Raw WALs found, starting archive process...", date)
+		repairErr = ArchiveRemoteDay(ctx, date, tg)
+	case StateNeedsMetadata:
+		log.Printf("🔧 [HEAL] %s: Archive exists, metadata missing. Regenerating...", date)
+		repairErr = HealMetadataFromArchive(ctx, date, scan.baseTime, tg)
+	case StateStaleWAL:
+		repairErr = purgeStaleWAL(ctx, date, scan)
+	case StateLost:
+		if !scan.hasMeta {
+			log.Printf("🚨 [HEAL] %s: DATA LOSS DETECTED.", date)
+			HandleTotalDataLoss(ctx, date, tg)
+		}
+		res.Outcome = OutcomeLost
+		return res
+	default:
+		// no_base, gaps, bad_base, base_only: nothing left to rebuild
+		res.Outcome = OutcomeDegraded
+		return res
+	}
+
+	if repairErr != nil {
+		res.Outcome = OutcomeFailed
+		res.Detail = repairErr.Error()
+		return res
+	}
+	after, err := scanDay(ctx, date)
+	if err != nil {
+		res.Outcome = OutcomeRepaired
+		return res
+	}
+	res.Final = classifyDay(after)
+	switch res.Final {
+	case StateHealthy:
+		res.Outcome = OutcomeRepaired
+	case StateLost:
+		res.Outcome = OutcomeLost
+	default:
+		res.Outcome = OutcomeDegraded
+	}
+	return res
+}
+
+func scanDay(ctx context.Context, date string) (dayScan, error) {
+	remoteRoot := project.From(ctx).WALRemote(date)
+	var scan dayScan
+
+	out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "lsjson", remoteRoot+"/").Output()
+	if err != nil {
+		return scan, fmt.Errorf("list %s: %w", date, err)
+	}
+	var items []RcloneItem
+	if err := json.Unmarshal(out, &items); err != nil {
+		return scan, err
+	}
+	for _, item := range items {
+		switch {
+		case isWALArchive(item.Name):
+			scan.hasArchive = true
+		case item.Name == "metadata.json":
+			scan.hasMeta = true
+		case item.Name == "base.tar.gz":
+			scan.hasBase = true
+			scan.baseTime = item.ModTime
+		case item.Name == "WAL" && item.IsDir:
+			scan.hasWAL = true
+		}
+	}
+
+	if scan.hasMeta {
+		if out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remoteRoot+"/metadata.json").Output(); err == nil {
+			var m api.PitrMetadata
+			if json.Unmarshal(out, &m) == nil {
+				scan.meta = &m
+			}
+		}
+	}
+	if scan.hasWAL {
+		if segs, err := listRemote(ctx, remoteRoot+"/WAL"); err == nil {
+			scan.walCount = len(segs)
+		}
+	}
+	return scan, nil
+}
+
+func classifyDay(s dayScan) DayState {
+	switch {
+	case s.hasWAL && !s.hasArchive:
+		return StateNeedsArchive
+	case s.hasWAL && s.hasArchive:
+		return StateStaleWAL
+	case s.hasArchive && !s.hasMeta:
+		return StateNeedsMetadata
+	case !s.hasArchive && s.hasBase:
+		return StateBaseOnly
+	case !s.hasArchive:
+		return StateLost
+	case !s.hasBase:
+		return StateNoBase
+	case s.meta != nil && !s.meta.Continuous:
+		return StateGaps
+	case s.meta != nil && s.meta.BaseVerification != nil && !s.meta.BaseVerification.OK:
+		return StateBadBase
+	}
+	return StateHealthy
+}
+
+// purgeStaleWAL deletes the raw WAL segments the day's verified archive
+// holds, on the primary and then on the mirrors. Segments missing from the
+// archive (uploaded after it was built) are kept and reported: they are the
+// only copy and need a manual re-archive.
+func purgeStaleWAL(ctx context.Context, date string, s dayScan) error {
+	if s.meta == nil || s.meta.WALArchive == nil || !s.meta.WALArchive.Verified {
+		return fmt.Errorf("raw WAL and an unverified archive both present, review manually")
+	}
+	p := project.From(ctx)
+	remote := p.WALRemote(date, "WAL")
+	name := s.meta.WALArchive.Name
+	if name == "" {
+		name = walArchiveName("gzip") // metadata written before archives were named
+	}
+	archived, _, err := readWALArchive(ctx, p.WALRemote(date, name))
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	segs, err := listRemote(ctx, remote)
+	if err != nil {
+		return fmt.Errorf("list raw WAL: %w", err)
+	}
+	var names, leftover []string
+	for _, seg := range segs {
+		if _, ok := archived[seg.Name]; ok {
+			names = append(names, seg.Name)
+		} else {
+			leftover = append(leftover, seg.Name)
+		}
+	}
+
+	if len(names) > 0 {
+		log.Printf("🧹 [HEAL] %s: archive verified, deleting %d archived raw WALs", date, len(names))
+		if err := deleteRemoteFiles(ctx, remote, names); err != nil {
+			return err
+		}
+		if replica.Copy(ctx, p, p.WALRemote(date)) == nil {
+			replica.Delete(ctx, p, remote, names)
+		}
+	}
+	if len(leftover) > 0 {
+		return fmt.Errorf("%d raw WAL segments are not in the archive (first %s), kept, re-archive manually", len(leftover), leftover[0])
+	}
+	return nil
+}
+
+func loadBackfillRun(path string) *backfillRun {
+	run := &backfillRun{Started: time.Now(), Days: make(map[string]dayResult), path: path}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return run
+	}
+	var prev backfillRun
+	if json.Unmarshal(b, &prev) != nil || !prev.Finished.IsZero() || prev.Days == nil {
+		return run
+	}
+	return &backfillRun{Started: prev.Started, Days: prev.Days, path: path}
+}
+
+func (r *backfillRun) record(date string, res dayResult) {
+	r.mu.Lock()
+	r.Days[date] = res
+	r.mu.Unlock()
+	r.save()
+}
+
+func (r *backfillRun) save() {
+	r.mu.Lock()
+	b, _ := json.MarshalIndent(r, "", "  ")
+	r.mu.Unlock()
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err == nil {
+		os.Rename(tmp, r.path)
+	}
+}
+
+func formatBackfillSummary(run *backfillRun, dates []string) string {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	counts := make(map[Outcome]int)
+	var rows []string
+	sorted := append([]string{}, dates...)
+	sort.Strings(sorted)
+	for _, d := range sorted {
+		r, ok := run.Days[d]
+		if !ok {
+			continue
+		}
+		counts[r.Outcome]++
+		if r.Outcome == OutcomeHealthy {
+			continue
+		}
+		state := string(r.State)
+		if r.Final != "" && r.Final != r.State {
+			state += " → " + string(r.Final)
+		}
+		row := fmt.Sprintf("%s  %-9s %s", d, r.Outcome, state)
+		if r.Detail != "" {
+			row += "  (" + truncateText(r.Detail, 60) + ")"
+		}
+		rows = append(rows, row)
+	}
+
+	msg := fmt.Sprintf("🧭 *Backfill finished: %d days*\n✅ healthy %d · 🔧 repaired %d · ⚠️ degraded %d · 🛑 lost %d · ❌ failed %d",
+		len(dates), counts[OutcomeHealthy], counts[OutcomeRepaired], counts[OutcomeDegraded], counts[OutcomeLost], counts[OutcomeFailed])
+	if len(rows) > 0 {
+		if len(rows) > 40 {
+			rows = append(rows[:40], fmt.Sprintf("… %d more", len(rows)-40))
+		}
+		msg += "\n```\n" + strings.Join(rows, "\n") + "\n```"
+	}
+	return msg
+}
@@ -1,24 +1,37 @@
 package tasks
 
 import (
+	"context"
+	"fmt"
 	"log"
-	"os/exec"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
-// RunFullBackup handles logical pg_dump snapshots to Dropbox
-func RunFullBackup(tg *telegram.Service) {
-	log.Println("📂 [BACKUP] Starting logical snapshot (pg_dump)...")
-	
-	cmd := exec.Command("/bin/sh", "/app/legacy_scripts/backup.sh")
-	output, err := cmd.CombinedOutput()
-	
+// RunFullBackup takes a logical snapshot set of the whole cluster to Dropbox
+func RunFullBackup(ctx context.Context, tg *telegram.Service) (err error) {
+	ctx, finish, err := begin(ctx, tg, "snapshot")
 	if err != nil {
-		log.Printf("❌ [BACKUP] Execution Error: %v\nOutput: %s", err, string(output))
-		tg.Send("⚠️ Watchdog failed to execute the logical backup script. Check container logs.")
-		return
+		return err
 	}
-	
+	defer func() { finish(err == nil) }()
+
+	log.Printf("📂 [BACKUP] Starting logical snapshot set (%s pg_dump of every database + globals)...", SnapshotFormat)
+
+	set, err := takeSnapshotSet(ctx)
+	if len(set.Databases) > 0 || set.Globals != nil {
+		// Whatever reached the primary, complete or not, goes to the replicas too.
+		p := project.From(ctx)
+		replica.Copy(ctx, p, p.SnapshotRemote(set.Day))
+	}
+	if err != nil {
+		log.Printf("❌ [BACKUP] Snapshot set %s: %v", set.Name, err)
+		tg.Send(fmt.Sprintf("⚠️ Snapshot set %s is incomplete: %v", set.Name, err))
+		return fmt.Errorf("snapshot set: %w", err)
+	}
+
 	// Success is now silent on Telegram
-	log.Println("✅ [BACKUP] Snapshot finished successfully.")
+	log.Printf("✅ [BACKUP] Snapshot set %s finished: %d databases + globals.", set.Name, len(set.Databases))
+	return nil
 }
\ No newline at end of file
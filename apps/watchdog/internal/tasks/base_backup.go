@@ -1,116 +1,233 @@
 package tasks
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"strings"
 	"time"
 
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/db"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
 // CheckAndRunStartupBaseBackup checks if today has a base backup. If not, runs one.
-func CheckAndRunStartupBaseBackup(tg *telegram.Service) {
-	// 1. Ensure the DB allows replication from supabase_admin via IPv4 loopback
-	log.Println("🛠️ [BASE] Ensuring replication permissions in pg_hba.conf...")
-	
-	// We use the path /etc/postgresql/pg_hba.conf which the DB reported as the active one.
-	// We add a 'host' rule for 127.0.0.1 to match the -h 127.0.0.1 flag used later.
-	hbaFix := `
-		HBA_FILE="/etc/postgresql/pg_hba.conf"
-		if ! grep -q "host replication supabase_admin 127.0.0.1/32" "$HBA_FILE"; then
-			echo "host replication supabase_admin 127.0.0.1/32 trust" >> "$HBA_FILE"
-			psql -U supabase_admin -d postgres -c "SELECT pg_reload_conf();"
-			echo "✅ [BASE] pg_hba.conf updated and reloaded."
-		fi
-	`
-	exec.Command("docker", "exec", "supabase-db", "sh", "-c", hbaFix).Run()
+func CheckAndRunStartupBaseBackup(ctx context.Context, tg *telegram.Service) {
+	// 1. Make sure the replication role exists and pg_hba lets it in
+	prepareReplication(ctx, tg)
 
 	// 2. Check if today's backup already exists on Dropbox
 	today := time.Now().Format("2006-01-02")
-	remoteFile := fmt.Sprintf("dropbox:SupabaseServerBackups_WAL/%s/base.tar.gz", today)
+	remoteFile := project.From(ctx).WALRemote(today, "base.tar.gz")
 
 	log.Printf("🔍 [BASE] Checking for %s base backup on Dropbox...", today)
-	cmd := exec.Command("docker", "exec", "supabase-rclone", "rclone", "lsf", remoteFile)
+	cmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "lsf", remoteFile)
 	out, _ := cmd.Output()
 
 	if strings.TrimSpace(string(out)) == "" {
 		log.Println("🚨 [BASE] TODAY HAS NO BACKUP. Starting immediate generation...")
 		tg.Send("🚨 Alert: Today has no base backup. Starting one immediately.")
-		RunDailyBaseBackup(tg)
+		RunDailyBaseBackup(ctx, tg)
 	} else {
 		log.Println("✅ [BASE] Today is already initialized on Dropbox.")
 	}
 }
 
 // RunDailyBaseBackup creates a physical replication slot backup (pg_basebackup)
-func RunDailyBaseBackup(tg *telegram.Service) {
+func RunDailyBaseBackup(ctx context.Context, tg *telegram.Service) (err error) {
+	ctx, finish, err := begin(ctx, tg, "base_backup")
+	if err != nil {
+		return err
+	}
+	defer func() { finish(err == nil) }()
+
 	today := time.Now().Format("2006-01-02")
-	
-	// Paths inside the DB container
-	dbTempDir := "/tmp/basebackup_gen"
-	
-	// Path inside the Watchdog container
-	localPath := fmt.Sprintf("/app/backup/base_%s.tar.gz", today)
-	
-	// Path inside the Rclone container (maps to the same host volume)
-	rcloneSourcePath := fmt.Sprintf("/backup/base_%s.tar.gz", today)
-	
-	// Remote Destination
-	remoteDest := fmt.Sprintf("dropbox:SupabaseServerBackups_WAL/%s/base.tar.gz", today)
-
-	log.Println("🐘 [BASE] Starting pg_basebackup (this may take a few minutes)...")
-
-	// 1. Cleanup previous attempts inside DB container
-	exec.Command("docker", "exec", "supabase-db", "rm", "-rf", dbTempDir).Run()
-
-	// 2. Run pg_basebackup
-	// Added -h 127.0.0.1 to force IPv4 loopback (matching our HBA fix)
-	cmd := exec.Command("docker", "exec", "supabase-db", 
-		"pg_basebackup", 
-		"-h", "127.0.0.1", 
-		"-U", "supabase_admin", 
-		"-D", dbTempDir, 
-		"-Ft", "-z", "-X", "none")
-	
-	if out, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("❌ [BASE] pg_basebackup failed: %s", string(out))
+	remoteRoot := project.From(ctx).WALRemote(today)
+	os.MkdirAll(baseChainDir(ctx), 0755)
+
+	// Incremental against the previous backup's manifest when the chain
+	// allows it; a failed incremental (e.g. missing WAL summaries) falls back
+	// to a full backup in the same run.
+	parent := planBaseBackup(ctx, today)
+	info, err := streamBaseBackup(ctx, today, remoteRoot, parent)
+	if err != nil && parent != nil && ctx.Err() == nil {
+		log.Printf("⚠️ [BASE] Incremental backup failed (%v), retrying as full", err)
+		info, err = streamBaseBackup(ctx, today, remoteRoot, nil)
+	}
+	if err != nil {
+		log.Printf("❌ [BASE] Streaming base backup failed: %v", err)
 		tg.Send("❌ Physical Base Backup Failed! check watchdog logs.")
-		return
+		return err
 	}
 
-	// 3. Copy out of DB container into the shared backup volume
-	log.Println("⬇️ [BASE] Copying backup from DB container to watchdog volume...")
-	cpCmd := exec.Command("docker", "cp", fmt.Sprintf("supabase-db:%s/base.tar.gz", dbTempDir), localPath)
-	if err := cpCmd.Run(); err != nil {
-		log.Printf("❌ [BASE] Docker CP failed: %v", err)
-		tg.Send("❌ Failed to copy base backup out of DB container.")
-		return
+	if err := saveBaseInfo(ctx, remoteRoot, info); err != nil {
+		log.Printf("⚠️ [BASE] Could not record backup chain: %v", err)
 	}
+	replica.Copy(ctx, project.From(ctx), remoteRoot)
+
+	log.Printf("✅ [BASE] Successfully archived %s base backup for %s (chain of %d)", info.Type, today, len(info.Chain))
+	tg.Send(fmt.Sprintf("✅ Daily Base Backup (%s, %s) completed and uploaded for %s.", info.Type, humanBytes(info.Size), today))
+	return nil
+}
+
+// streamBaseBackup streams one base backup to remoteRoot/base.tar.gz, full
+// when parent is nil, and keeps its manifest for the next incremental.
+func streamBaseBackup(ctx context.Context, today, remoteRoot string, parent *api.BaseBackupInfo) (api.BaseBackupInfo, error) {
+	info := api.BaseBackupInfo{Date: today, Type: "full", Chain: []string{today}, Started: time.Now().UTC()}
+
+	// Remote Destination. The stream lands under a temporary name and is only
+	// renamed once complete, so a broken run never looks like today's base.
+	remoteDest := remoteRoot + "/base.tar.gz"
+	remotePartial := remoteDest + ".partial"
 
-	// 4. Cleanup DB container temp files immediately
-	exec.Command("docker", "exec", "supabase-db", "rm", "-rf", dbTempDir).Run()
+	// pg_basebackup reads the parent manifest client-side, i.e. inside the DB container.
+	// The password is passed through the environment, never on the command line.
+	p := project.From(ctx)
+	user, password, err := replicationLogin(p)
+	if err != nil {
+		return info, err
+	}
+	args := []string{"exec", "-e", "PGPASSWORD", p.DBContainer,
+		"pg_basebackup",
+		"-h", "127.0.0.1",
+		"-U", user,
+		"-D", "-",
+		"-Ft", "-z", "-X", "none", "--progress"}
+	if parent != nil {
+		dbManifest := "/tmp/watchdog_parent_manifest"
+		if out, err := exec.CommandContext(ctx, "docker", "cp", manifestPath(ctx, parent.Date), p.DBContainer+":"+dbManifest).CombinedOutput(); err != nil {
+			return info, fmt.Errorf("copy parent manifest: %w: %s", err, out)
+		}
+		defer exec.Command("docker", "exec", p.DBContainer, "rm", "-f", dbManifest).Run()
+		args = append(args, "--incremental="+dbManifest)
+		info.Type = "incremental"
+		info.Parent = parent.Date
+		info.Chain = append(append([]string{}, parent.Chain...), today)
+	}
+
+	log.Printf("🐘 [BASE] Streaming %s pg_basebackup to Dropbox (this may take a few minutes)...", info.Type)
+
+	// 1. Run pg_basebackup with the tar written to stdout and piped straight
+	// into rclone: no copy in /tmp of the DB container, none in /app/backup.
+	// Added -h 127.0.0.1 to force IPv4 loopback (the address the HBA check looks at)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+
+	lastPct := -1
+	onProgress := func(line string, sent int64) {
+		done, total, pct, ok := parseBasebackupProgress(line)
+		if !ok {
+			log.Printf("🐘 [BASE] %s", line)
+			return
+		}
+		if pct == lastPct {
+			return
+		}
+		lastPct = pct
+		reportProgress(ctx, Progress{Task: "base_backup", Percent: pct, Done: done, Total: total, Sent: sent})
+		if pct%10 == 0 {
+			log.Printf("📦 [BASE] %d%% (%s of %s read, %s uploaded)", pct, humanBytes(done), humanBytes(total), humanBytes(sent))
+		}
+	}
+
+	// The manifest travels inside the tar stream; keep a copy on the side and
+	// check every file against it while the stream goes by.
+	manifest := catchManifest(manifestPath(ctx, today))
+	verifier := newBackupVerifier("upload", "CRC32C") // pg_basebackup's default
+	size, err := streamUpload(ctx, cmd, remotePartial, io.MultiWriter(manifest, verifier), onProgress)
+	manifestErr := manifest.Wait()
+	check := verifier.Wait()
+	if err == nil && !check.OK {
+		err = fmt.Errorf("verification against backup_manifest failed: %s", check.Error)
+	}
+	if err != nil {
+		// Do not leave a half-written or broken tar behind
+		exec.Command("docker", "exec", "supabase-rclone", "rclone", "deletefile", remotePartial).Run()
+		os.Remove(manifestPath(ctx, today))
+		return info, fmt.Errorf("pg_basebackup stream: %w", err)
+	}
+	info.Size = size
+	info.Verification = &check
+	log.Printf("🔎 [BASE] Verified %d files against backup_manifest", check.Files)
 
-	// 5. Upload to Dropbox via the rclone container
-	log.Printf("⬆️ [BASE] Uploading to Dropbox path: %s", remoteDest)
-	
-	// IMPORTANT: We tell rclone to look at rcloneSourcePath (/backup/...) 
-	// because rclone's container sees the volume there.
-	upCmd := exec.Command("docker", "exec", "supabase-rclone", "rclone", 
+	// 2. Publish under the final name
+	mvCmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone",
 		"--config", "/config/rclone/rclone.conf",
-		"copyto", rcloneSourcePath, remoteDest)
-	
-	if out, err := upCmd.CombinedOutput(); err != nil {
-		log.Printf("❌ [BASE] Rclone upload failed: %s", string(out))
-		tg.Send("❌ Base Backup Upload Failed.")
+		"moveto", remotePartial, remoteDest)
+	if out, err := mvCmd.CombinedOutput(); err != nil {
+		return info, fmt.Errorf("rclone moveto: %w: %s", err, out)
+	}
+
+	// 3. Keep the manifest next to the backup for restores and verification
+	if manifestErr != nil {
+		log.Printf("⚠️ [BASE] No backup_manifest captured, next backup will be full: %v", manifestErr)
+	} else {
+		exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "copyto",
+			manifestPath(ctx, today), remoteRoot+"/backup_manifest").Run()
+	}
+	return info, nil
+}
+
+// prepareReplication ensures the replication role from the Docker secret and
+// reports pg_hba problems. It never edits pg_hba.conf: fixing access rules is
+// left to whoever owns the database config.
+func prepareReplication(ctx context.Context, tg *telegram.Service) {
+	p := project.From(ctx)
+	password, err := db.ReplicationPassword(p.ReplicationSecret)
+	if err != nil {
+		user, _, lerr := replicationLogin(p)
+		if lerr != nil {
+			log.Printf("❌ [REPL] %v", lerr)
+			tg.Send(fmt.Sprintf("❌ [REPL] No replication login, base backups will fail: %v", lerr))
+			return
+		}
+		log.Printf("⚠️ [REPL] %v, base backups fall back to %s", err, user)
+		tg.Send(fmt.Sprintf("⚠️ [REPL] No replication secret (%v). Base backups run as %s.", err, user))
+		return
+	}
+	if err := db.EnsureReplicationRole(ctx, password); err != nil {
+		log.Printf("❌ [REPL] Could not manage role %s: %v", db.ReplicationUser, err)
+		tg.Send(fmt.Sprintf("❌ [REPL] Could not create/update replication role %s: %v", db.ReplicationUser, err))
 		return
 	}
 
-	// 6. Cleanup local file in the watchdog container
-	os.Remove(localPath)
+	problems, err := db.CheckReplicationHBA(ctx, db.ReplicationUser)
+	if err != nil {
+		log.Printf("⚠️ [REPL] Cannot read pg_hba_file_rules: %v", err)
+		return
+	}
+	if len(problems) == 0 {
+		log.Printf("✅ [REPL] Role %s and pg_hba rules look good", db.ReplicationUser)
+		return
+	}
+	for _, p := range problems {
+		log.Printf("⚠️ [REPL] pg_hba: %s", p)
+	}
+	tg.Send("🛡️ *pg_hba drift detected* (not changed automatically)\n• " + strings.Join(problems, "\n• "))
+}
 
-	log.Printf("✅ [BASE] Successfully archived base backup for %s", today)
-	tg.Send(fmt.Sprintf("✅ Daily Base Backup completed and uploaded for %s.", today))
-}
\ No newline at end of file
+// replicationLogin returns the credentials pg_basebackup connects with: the
+// replication role with the project's secret, else the main stack's
+// POSTGRES_PASSWORD for main and the login of database_url for the others.
+func replicationLogin(p *project.Project) (user, password string, err error) {
+	if pw, err := db.ReplicationPassword(p.ReplicationSecret); err == nil {
+		return db.ReplicationUser, pw, nil
+	}
+	if p.IsDefault() {
+		return p.DBUser, config.Env("POSTGRES_PASSWORD", ""), nil
+	}
+	if u, err := url.Parse(p.DatabaseURL); err == nil && u.User != nil {
+		if pw, ok := u.User.Password(); ok {
+			return u.User.Username(), pw, nil
+		}
+	}
+	return "", "", fmt.Errorf("project %s has no replication secret at %s and no password in database_url", p.Name, p.ReplicationSecret)
+}
@@ -0,0 +1,322 @@
+package tasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/db"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// Manifests and chain records of recent base backups, in the project's state
+// dir. The manifest of the previous backup is what the next incremental
+// backup is taken against.
+func baseChainDir(ctx context.Context) string { return project.From(ctx).State("base_chain") }
+
+// FullBackupEvery is the maximum chain length: a full base backup is taken
+// when the chain reaches it (BASE_FULL_EVERY, in days; 1 disables incrementals).
+var FullBackupEvery = func() int {
+	if n, err := strconv.Atoi(config.Env("BASE_FULL_EVERY", "7")); err == nil && n > 0 {
+		return n
+	}
+	return 7
+}()
+
+func baseInfoPath(ctx context.Context, date string) string {
+	return filepath.Join(baseChainDir(ctx), date+".json")
+}
+func manifestPath(ctx context.Context, date string) string {
+	return filepath.Join(baseChainDir(ctx), date+".backup_manifest")
+}
+
+// planBaseBackup decides whether today's backup can be incremental and
+// returns the parent to chain to, or nil for a full backup.
+func planBaseBackup(ctx context.Context, today string) *api.BaseBackupInfo {
+	if FullBackupEvery <= 1 {
+		return nil
+	}
+	if !ensureWALSummaries(ctx) {
+		return nil
+	}
+
+	parent := latestBaseInfo(ctx, today)
+	switch {
+	case parent == nil:
+		log.Println("🧱 [BASE] No previous base backup on record, taking a full one")
+		return nil
+	case len(parent.Chain) >= FullBackupEvery:
+		log.Printf("🧱 [BASE] Chain from %s reached %d backups, taking a full one", parent.Chain[0], len(parent.Chain))
+		return nil
+	}
+	if _, err := os.Stat(manifestPath(ctx, parent.Date)); err != nil {
+		log.Printf("🧱 [BASE] Manifest of %s missing, taking a full backup", parent.Date)
+		return nil
+	}
+	return parent
+}
+
+// ensureWALSummaries turns summarize_wal on if needed. Incremental backups
+// only work once summaries cover the parent's start, so the run that enables
+// it still takes a full backup.
+func ensureWALSummaries(ctx context.Context) bool {
+	pool, err := db.Pool(ctx)
+	if err != nil {
+		log.Printf("⚠️ [BASE] Cannot check summarize_wal: %v", err)
+		return false
+	}
+	var on string
+	if err := pool.QueryRow(ctx, `SHOW summarize_wal`).Scan(&on); err != nil {
+		log.Printf("⚠️ [BASE] Cannot check summarize_wal: %v", err)
+		return false
+	}
+	if on == "on" {
+		return true
+	}
+	log.Println("🛠️ [BASE] Enabling summarize_wal for incremental backups...")
+	if _, err := pool.Exec(ctx, `ALTER SYSTEM SET summarize_wal = on`); err != nil {
+		log.Printf("⚠️ [BASE] Could not enable summarize_wal: %v", err)
+		return false
+	}
+	pool.Exec(ctx, `SELECT pg_reload_conf()`)
+	return false
+}
+
+// latestBaseInfo returns the newest recorded base backup before today.
+func latestBaseInfo(ctx context.Context, today string) *api.BaseBackupInfo {
+	entries, _ := os.ReadDir(baseChainDir(ctx))
+	var dates []string
+	for _, e := range entries {
+		if d, ok := strings.CutSuffix(e.Name(), ".json"); ok && d < today {
+			dates = append(dates, d)
+		}
+	}
+	sort.Strings(dates)
+	for i := len(dates) - 1; i >= 0; i-- {
+		if info, err := readBaseInfo(ctx, dates[i]); err == nil {
+			return info
+		}
+	}
+	return nil
+}
+
+func readBaseInfo(ctx context.Context, date string) (*api.BaseBackupInfo, error) {
+	b, err := os.ReadFile(baseInfoPath(ctx, date))
+	if err != nil {
+		return nil, err
+	}
+	var info api.BaseBackupInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// saveBaseInfo records the chain locally and next to base.tar.gz, and drops
+// manifests no longer needed by any chain.
+func saveBaseInfo(ctx context.Context, remoteRoot string, info api.BaseBackupInfo) error {
+	b, _ := json.MarshalIndent(info, "", "  ")
+	if err := os.WriteFile(baseInfoPath(ctx, info.Date), b, 0644); err != nil {
+		return err
+	}
+	if err := uploadBaseInfo(ctx, remoteRoot, info); err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for _, d := range info.Chain {
+		keep[d] = true
+	}
+	dir := baseChainDir(ctx)
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		d := strings.TrimSuffix(strings.TrimSuffix(e.Name(), ".json"), ".backup_manifest")
+		if !keep[d] && d < info.Date {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+	return nil
+}
+
+// uploadBaseInfo writes <day>/base_backup.json to remote storage only.
+func uploadBaseInfo(ctx context.Context, remoteRoot string, info api.BaseBackupInfo) error {
+	b, _ := json.MarshalIndent(info, "", "  ")
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-i", "supabase-rclone", "rclone",
+		"rcat", remoteRoot+"/base_backup.json")
+	cmd.Stdin = bytes.NewReader(b)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("upload base_backup.json: %w: %s", err, out)
+	}
+	replica.Copy(ctx, project.From(ctx), remoteRoot+"/base_backup.json")
+	return nil
+}
+
+// fetchBaseInfo reads <day>/base_backup.json from remote storage.
+func fetchBaseInfo(ctx context.Context, remoteRoot string) (*api.BaseBackupInfo, error) {
+	out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remoteRoot+"/base_backup.json").Output()
+	if err != nil {
+		return nil, err
+	}
+	var info api.BaseBackupInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// manifestCatcher watches a gzipped tar stream go by and saves the
+// backup_manifest member pg_basebackup injects when writing to stdout.
+type manifestCatcher struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func catchManifest(path string) *manifestCatcher {
+	pr, pw := io.Pipe()
+	m := &manifestCatcher{pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := extractManifest(pr, path)
+		io.Copy(io.Discard, pr) // keep the stream flowing whatever happened
+		m.done <- err
+	}()
+	return m
+}
+
+func (m *manifestCatcher) Write(p []byte) (int, error) { return m.pw.Write(p) }
+
+// Wait ends the stream and reports whether the manifest was found.
+func (m *manifestCatcher) Wait() error {
+	m.pw.Close()
+	return <-m.done
+}
+
+func extractManifest(r io.Reader, path string) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return errors.New("backup_manifest not found in stream")
+			}
+			return err
+		}
+		if hdr.Name != "backup_manifest" {
+			continue
+		}
+		tmp := path + ".tmp"
+		f, err := os.Create(tmp)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+		f.Close()
+		return os.Rename(tmp, path)
+	}
+}
+
+// CombineBaseChain rebuilds a full data directory for date inside the DB
+// container: every backup of its chain is streamed in and extracted, then
+// pg_combinebackup merges them. It returns the output directory, ready for
+// a restore with restore_command pointing at the WAL archive.
+func CombineBaseChain(ctx context.Context, date string, tg *telegram.Service) (_ string, err error) {
+	ctx, finish, err := begin(ctx, tg, "combine_base")
+	if err != nil {
+		return "", err
+	}
+	defer func() { finish(err == nil) }()
+
+	p := replica.Reader(ctx, project.From(ctx))
+	ctx = project.With(ctx, p)
+	remoteRoot := p.WALRemote(date)
+	info, err := fetchBaseInfo(ctx, remoteRoot)
+	if err != nil {
+		// Days before incremental backups existed are standalone full backups.
+		info = &api.BaseBackupInfo{Date: date, Type: "full", Chain: []string{date}}
+	}
+
+	work := "/var/lib/postgresql/pitr_combine"
+	output := fmt.Sprintf("/var/lib/postgresql/pitr_restore/%s", date)
+	// Run as postgres so the result can be used as a data directory as-is.
+	exec.CommandContext(ctx, "docker", "exec", "-u", "postgres", p.DBContainer, "sh", "-c",
+		fmt.Sprintf("rm -rf %s %s && mkdir -p %s", work, output, filepath.Dir(output))).Run()
+	defer exec.Command("docker", "exec", p.DBContainer, "rm", "-rf", work).Run()
+
+	var dirs []string
+	for _, d := range info.Chain {
+		dir := filepath.Join(work, d)
+		log.Printf("⬇️ [COMBINE] Fetching base backup %s (%s)...", d, info.Type)
+		fetch := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat",
+			p.WALRemote(d, "base.tar.gz"))
+		extract := exec.CommandContext(ctx, "docker", "exec", "-i", "-u", "postgres", p.DBContainer, "sh", "-c",
+			fmt.Sprintf("mkdir -p %s && tar -xzf - -C %s", dir, dir))
+		if err := pipeCommands(fetch, extract); err != nil {
+			tg.Send(fmt.Sprintf("❌ [COMBINE] Could not fetch base backup %s of the %s chain: %v", d, date, err))
+			return "", fmt.Errorf("fetch %s: %w", d, err)
+		}
+		dirs = append(dirs, dir)
+	}
+
+	if len(dirs) == 1 {
+		if out, err := exec.CommandContext(ctx, "docker", "exec", "-u", "postgres", p.DBContainer, "mv", dirs[0], output).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("mv: %w: %s", err, out)
+		}
+	} else {
+		log.Printf("🧩 [COMBINE] pg_combinebackup over %d backups (%s..%s)", len(dirs), info.Chain[0], date)
+		args := append([]string{"exec", "-u", "postgres", p.DBContainer, "pg_combinebackup", "-o", output}, dirs...)
+		if out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput(); err != nil {
+			tg.Send(fmt.Sprintf("❌ [COMBINE] pg_combinebackup failed for %s: %s", date, lastLines(string(out), 3)))
+			return "", fmt.Errorf("pg_combinebackup: %w: %s", err, out)
+		}
+	}
+
+	log.Printf("✅ [COMBINE] Data directory for %s ready at %s:%s", date, p.DBContainer, output)
+	tg.Send(fmt.Sprintf("🧩 Base backup chain for %s combined (%d backups) into %s:%s", date, len(dirs), p.DBContainer, output))
+	return output, nil
+}
+
+// pipeCommands runs src | dst.
+func pipeCommands(src, dst *exec.Cmd) error {
+	pr, pw := io.Pipe()
+	src.Stdout = pw
+	dst.Stdin = pr
+	var srcErr, dstErr strings.Builder
+	src.Stderr = &srcErr
+	dst.Stderr = &dstErr
+
+	if err := dst.Start(); err != nil {
+		return err
+	}
+	err := src.Run()
+	pw.CloseWithError(err)
+	if werr := dst.Wait(); werr != nil && err == nil {
+		return fmt.Errorf("%w: %s", werr, strings.TrimSpace(dstErr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(srcErr.String()))
+	}
+	return nil
+}
@@ -1,16 +1,303 @@
 package tasks
 
 import (
-	"os/exec"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/docker"
+	"github.com/GoldenCarrotMLP/watchdog/internal/lock"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
-func RunDiskCleanup(tg *telegram.Service) {
+// CleanupConfig is the format of cleanup.json. Protect entries are globs over
+// image references (repo:tag) and volume names that must never be removed.
+type CleanupConfig struct {
+	ProtectLabel  string   `json:"protect_label"`
+	ProtectImages []string `json:"protect_images"`
+	ProtectVols   []string `json:"protect_volumes"`
+}
+
+var defaultCleanupConfig = CleanupConfig{
+	ProtectLabel:  "watchdog.protect",
+	ProtectImages: []string{"supabase/*", "postgres*", "rclone/*"},
+	ProtectVols:   []string{"*db*", "*grafana*", "*prometheus*", "*mail*"},
+}
+
+// Docker /system/df response, reduced to what cleanup needs.
+type systemDF struct {
+	Images []struct {
+		ID         string            `json:"Id"`
+		RepoTags   []string          `json:"RepoTags"`
+		Size       int64             `json:"Size"`
+		SharedSize int64             `json:"SharedSize"`
+		Labels     map[string]string `json:"Labels"`
+	} `json:"Images"`
+	Containers []struct {
+		ID      string            `json:"Id"`
+		Names   []string          `json:"Names"`
+		Image   string            `json:"Image"`
+		ImageID string            `json:"ImageID"`
+		State   string            `json:"State"`
+		SizeRw  int64             `json:"SizeRw"`
+		Labels  map[string]string `json:"Labels"`
+	} `json:"Containers"`
+	Volumes []struct {
+		Name      string            `json:"Name"`
+		Labels    map[string]string `json:"Labels"`
+		UsageData struct {
+			Size     int64 `json:"Size"`
+			RefCount int64 `json:"RefCount"`
+		} `json:"UsageData"`
+	} `json:"Volumes"`
+	BuildCache []struct {
+		ID     string `json:"ID"`
+		Size   int64  `json:"Size"`
+		InUse  bool   `json:"InUse"`
+		Shared bool   `json:"Shared"`
+	} `json:"BuildCache"`
+}
+
+// CleanupItem is one reclaimable object.
+type CleanupItem struct {
+	Kind string // container, image, volume, build-cache
+	ID   string
+	Name string
+	Size int64
+
+	tags  []string // repo:tag references of an image
+	image string   // image ID of a container
+}
+
+// Compose-managed and named volumes are never pruned, only anonymous ones.
+var anonVolume = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// RunDiskCleanup reclaims space through the Docker API. With dryRun set it only
+// reports what would be removed. Protected images and volumes are always kept.
+func RunDiskCleanup(ctx context.Context, tg *telegram.Service, dryRun bool) error {
+	release, err := lock.Acquire(ctx, "disk_cleanup", lock.Skip)
+	if err != nil {
+		log.Printf("⏳ [CLEANUP] Request ignored: %v", err)
+		tg.Send(fmt.Sprintf("⏳ Disk cleanup request ignored: %v", err))
+		return err
+	}
+	defer release()
+
+	cfg := defaultCleanupConfig
+	if _, err := config.Load(config.Env("CLEANUP_CONFIG_FILE", config.Path("cleanup.json")), &cfg); err != nil {
+		log.Printf("❌ [CLEANUP] %v, using defaults", err)
+		cfg = defaultCleanupConfig
+	}
+
+	client := docker.NewWithTimeout(5 * time.Minute)
+	items, err := findReclaimable(client, cfg)
+	if err != nil {
+		log.Printf("❌ [CLEANUP] Docker API error: %v", err)
+		tg.Send(fmt.Sprintf("❌ Disk cleanup failed: %v", err))
+		return err
+	}
+
+	if dryRun {
+		tg.Send(formatCleanupReport("🔎 Disk cleanup (dry run)", items, nil))
+		return nil
+	}
+
 	tg.Send("🧹 Starting Disk Cleanup...")
-	
-	// Execute the same commands your shell script used
-	exec.Command("docker", "system", "prune", "-a", "-f").Run()
-	exec.Command("docker", "volume", "prune", "-f").Run()
-	
-	tg.Send("✅ Cleanup Done")
-}
\ No newline at end of file
+	var removed []CleanupItem
+	var failed []string
+	held := map[string]bool{} // images of containers that could not be removed
+
+	// Containers first so their images become unused.
+removal:
+	for _, kind := range []string{"container", "image", "volume"} {
+		for _, it := range items {
+			if ctx.Err() != nil {
+				failed = append(failed, "interrupted by shutdown")
+				break removal
+			}
+			if it.Kind != kind {
+				continue
+			}
+			if it.Kind == "image" && held[it.ID] {
+				failed = append(failed, "image "+it.Name+" (container not removed)")
+				continue
+			}
+			if err := removeItem(client, it); err != nil {
+				log.Printf("⚠️ [CLEANUP] Could not remove %s %s: %v", it.Kind, it.Name, err)
+				failed = append(failed, it.Kind+" "+it.Name)
+				if it.Kind == "container" {
+					held[it.image] = true
+				}
+				continue
+			}
+			removed = append(removed, it)
+		}
+	}
+
+	var prune struct {
+		SpaceReclaimed int64 `json:"SpaceReclaimed"`
+	}
+	if ctx.Err() == nil && slices.ContainsFunc(items, func(it CleanupItem) bool { return it.Kind == "build-cache" }) {
+		if err := client.Post("/build/prune", nil, &prune); err != nil {
+			failed = append(failed, "build cache")
+		} else if prune.SpaceReclaimed > 0 {
+			removed = append(removed, CleanupItem{Kind: "build-cache", Name: "build cache", Size: prune.SpaceReclaimed})
+		}
+	}
+
+	if len(failed) > 0 {
+		tg.Send(formatCleanupReport("⚠️ Cleanup finished with errors", removed, failed))
+		return fmt.Errorf("could not remove %s", strings.Join(failed, ", "))
+	}
+	tg.Send(formatCleanupReport("✅ Cleanup Done", removed, failed))
+	return nil
+}
+
+func findReclaimable(client *docker.Client, cfg CleanupConfig) ([]CleanupItem, error) {
+	var df systemDF
+	if err := client.Get("/system/df", &df); err != nil {
+		return nil, err
+	}
+
+	// Images count as used only through containers that stay: the stopped
+	// ones removed below no longer hold theirs.
+	var items []CleanupItem
+	inUse := map[string]bool{}
+	for _, c := range df.Containers {
+		if c.State != "exited" && c.State != "created" && c.State != "dead" {
+			inUse[c.ImageID] = true
+			continue
+		}
+		if c.Labels[cfg.ProtectLabel] == "true" {
+			inUse[c.ImageID] = true
+			continue
+		}
+		name := c.ID[:12]
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		items = append(items, CleanupItem{Kind: "container", ID: c.ID, Name: name, Size: c.SizeRw, image: c.ImageID})
+	}
+
+	for _, img := range df.Images {
+		if inUse[img.ID] || img.Labels[cfg.ProtectLabel] == "true" {
+			continue
+		}
+		if matchesAny(cfg.ProtectImages, img.RepoTags...) {
+			continue
+		}
+		var tags []string
+		for _, t := range img.RepoTags {
+			if t != "<none>:<none>" {
+				tags = append(tags, t)
+			}
+		}
+		name := strings.TrimPrefix(img.ID, "sha256:")[:12]
+		if len(tags) > 0 {
+			name = tags[0]
+		}
+		items = append(items, CleanupItem{Kind: "image", ID: img.ID, Name: name, Size: img.Size - img.SharedSize, tags: tags})
+	}
+
+	for _, v := range df.Volumes {
+		if v.UsageData.RefCount != 0 || !anonVolume.MatchString(v.Name) {
+			continue
+		}
+		if v.Labels[cfg.ProtectLabel] == "true" || matchesAny(cfg.ProtectVols, v.Name) {
+			continue
+		}
+		items = append(items, CleanupItem{Kind: "volume", ID: v.Name, Name: v.Name[:12], Size: v.UsageData.Size})
+	}
+
+	// Only what /build/prune removes without "all": cache no build uses and
+	// no other record shares.
+	var cache int64
+	for _, b := range df.BuildCache {
+		if !b.InUse && !b.Shared {
+			cache += b.Size
+		}
+	}
+	if cache > 0 {
+		items = append(items, CleanupItem{Kind: "build-cache", Name: "build cache", Size: cache})
+	}
+	return items, nil
+}
+
+func removeItem(client *docker.Client, it CleanupItem) error {
+	switch it.Kind {
+	case "container":
+		return client.Delete("/containers/"+it.ID, nil)
+	case "image":
+		// Deleting a tagged image by ID fails with 409 while it has several
+		// tags; untagging each reference removes it with the last one. Only
+		// untagged images are forced, as nothing else names them.
+		if len(it.tags) == 0 {
+			return client.Delete("/images/"+it.ID, url.Values{"force": {"true"}, "noprune": {"false"}})
+		}
+		for _, ref := range it.tags {
+			if err := client.Delete("/images/"+ref, url.Values{"noprune": {"false"}}); err != nil {
+				return fmt.Errorf("untag %s: %w", ref, err)
+			}
+		}
+		return nil
+	case "volume":
+		return client.Delete("/volumes/"+it.ID, nil)
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, names ...string) bool {
+	for _, p := range patterns {
+		for _, n := range names {
+			if ok, _ := path.Match(p, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formatCleanupReport(title string, items []CleanupItem, failed []string) string {
+	totals := map[string]int64{}
+	counts := map[string]int{}
+	var sum int64
+	for _, it := range items {
+		totals[it.Kind] += it.Size
+		counts[it.Kind]++
+		sum += it.Size
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n", title)
+	for _, kind := range []string{"container", "image", "volume", "build-cache"} {
+		if counts[kind] == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "• %s: %d (%s)\n", kind, counts[kind], humanBytes(totals[kind]))
+	}
+	fmt.Fprintf(&b, "Total: %s", humanBytes(sum))
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "\n⚠️ Failed: %s", strings.Join(failed, ", "))
+	}
+	return b.String()
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,441 @@
+package tasks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// SnapshotFormat picks how logical snapshots are taken (SNAPSHOT_FORMAT):
+// plain streams gzipped SQL, custom streams pg_dump -Fc, directory runs
+// pg_dump -Fd with SNAPSHOT_JOBS workers and uploads the folder as a tar.
+// Custom and directory snapshots get their `pg_restore -l` stored as <file>.toc.
+var SnapshotFormat = config.Env("SNAPSHOT_FORMAT", "plain")
+
+// SnapshotJobs is the number of parallel pg_dump / pg_restore workers.
+var SnapshotJobs = func() int {
+	if n, err := strconv.Atoi(config.Env("SNAPSHOT_JOBS", "2")); err == nil && n > 0 {
+		return n
+	}
+	return 2
+}()
+
+// snapshotDatabase is the application database restores and drills default to.
+const snapshotDatabase = "postgres"
+
+func snapshotExt(format string) string {
+	switch format {
+	case "custom":
+		return ".dump"
+	case "directory":
+		return ".dir.tar"
+	default:
+		return ".sql.gz"
+	}
+}
+
+// dumpDatabase uploads one database of a snapshot set to <day>/ and checks
+// the stored size against what was streamed.
+func dumpDatabase(ctx context.Context, format, database, day, stamp string) (api.SnapshotSetFile, error) {
+	f := api.SnapshotSetFile{Database: database, Format: format,
+		File: fmt.Sprintf("supabase-%s-%s_%s%s", database, day, stamp, snapshotExt(format))}
+	p := project.From(ctx)
+	remote := p.SnapshotRemote(day, f.File)
+
+	var toc string
+	var err error
+	switch format {
+	case "directory":
+		toc, f.Size, err = dumpDirectory(ctx, database, remote)
+	case "custom":
+		toc, f.Size, err = dumpCustom(ctx, database, remote)
+	default:
+		dump := exec.CommandContext(ctx, "docker", "exec", p.DBContainer,
+			"pg_dump", "-U", p.DBUser, "--clean", "--if-exists", "-Z", "6", database)
+		f.Size, err = streamUpload(ctx, dump, remote, nil, nil)
+	}
+	if err != nil {
+		return f, err
+	}
+	if items, err := listRemote(ctx, remote); err != nil || len(items) != 1 || items[0].Size != f.Size || f.Size == 0 {
+		exec.Command("docker", "exec", "supabase-rclone", "rclone", "deletefile", remote).Run()
+		return f, fmt.Errorf("uploaded %s does not match the %s streamed (%v)", f.File, humanBytes(f.Size), err)
+	}
+
+	if format != "plain" {
+		if len(api.ParseTOC(toc)) == 0 {
+			exec.Command("docker", "exec", "supabase-rclone", "rclone", "deletefile", remote).Run()
+			return f, fmt.Errorf("%s has an empty table of contents", f.File)
+		}
+		if err := rcatFrom(ctx, strings.NewReader(toc), remote+".toc"); err != nil {
+			return f, fmt.Errorf("upload toc: %w", err)
+		}
+		f.TOC = true
+	}
+	log.Printf("✅ [BACKUP] %s: %s (%s)", database, f.File, humanBytes(f.Size))
+	return f, nil
+}
+
+// dumpCustom streams pg_dump -Fc to remote storage. A tee feeds the same
+// bytes to pg_restore -l, which only needs the header and TOC at the front.
+func dumpCustom(ctx context.Context, database, remote string) (string, int64, error) {
+	p := project.From(ctx)
+	dump := exec.CommandContext(ctx, "docker", "exec", p.DBContainer,
+		"pg_dump", "-U", p.DBUser, "-Fc", "-Z", "6", database)
+
+	pr, pw := io.Pipe()
+	var toc, tocErr bytes.Buffer
+	listed := make(chan error, 1)
+	go func() {
+		list := exec.CommandContext(ctx, "docker", "exec", "-i", p.DBContainer, "pg_restore", "-l")
+		list.Stdin = pr
+		list.Stdout = &toc
+		list.Stderr = &tocErr
+		err := list.Run()
+		io.Copy(io.Discard, pr) // keep the tee from blocking once the TOC is read
+		listed <- err
+	}()
+
+	size, err := streamUpload(ctx, dump, remote, pw, nil)
+	pw.Close()
+	if lerr := <-listed; err == nil && lerr != nil {
+		err = fmt.Errorf("pg_restore -l: %w: %s", lerr, lastLines(tocErr.String(), 3))
+	}
+	return toc.String(), size, err
+}
+
+// dumpDirectory runs a parallel pg_dump -Fd in the database container's /tmp,
+// lists it and streams it out as a tar.
+func dumpDirectory(ctx context.Context, database, remote string) (string, int64, error) {
+	p := project.From(ctx)
+	dir := fmt.Sprintf("/tmp/watchdog_dump_%s_%d", database, time.Now().Unix())
+	defer exec.Command("docker", "exec", p.DBContainer, "rm", "-rf", dir).Run()
+
+	if out, err := exec.CommandContext(ctx, "docker", "exec", p.DBContainer, "pg_dump", "-U", p.DBUser,
+		"-Fd", "-j", strconv.Itoa(SnapshotJobs), "-Z", "6", "-f", dir, database).CombinedOutput(); err != nil {
+		return "", 0, fmt.Errorf("pg_dump: %w: %s", err, lastLines(string(out), 3))
+	}
+	toc, err := exec.CommandContext(ctx, "docker", "exec", p.DBContainer, "pg_restore", "-l", dir).Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("pg_restore -l: %w", err)
+	}
+	tar := exec.CommandContext(ctx, "docker", "exec", p.DBContainer, "tar", "-C", dir, "-cf", "-", ".")
+	size, err := streamUpload(ctx, tar, remote, nil, nil)
+	return string(toc), size, err
+}
+
+// stageDump copies a custom or directory snapshot into the container's /tmp
+// so pg_restore can seek in it and run with several jobs.
+func stageDump(ctx context.Context, container, remote, format string) (string, func(), error) {
+	path := fmt.Sprintf("/tmp/watchdog_restore_%d", time.Now().UnixNano())
+	cleanup := func() { exec.Command("docker", "exec", container, "rm", "-rf", path).Run() }
+
+	src := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remote)
+	dst := exec.CommandContext(ctx, "docker", "exec", "-i", container, "sh", "-c", "cat > "+path)
+	if format == "directory" {
+		dst = exec.CommandContext(ctx, "docker", "exec", "-i", container, "sh", "-c", "mkdir -p "+path+" && tar -xf - -C "+path)
+	}
+	if err := pipeCommands(src, dst); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("stage %s: %w", remote, err)
+	}
+	return path, cleanup, nil
+}
+
+// pgRestoreFile runs pg_restore on a staged archive and counts the errors it
+// reported; pg_restore keeps going past failed statements and then exits 1
+// after "errors ignored on restore". Any other failure means it stopped early
+// and is returned along with the count.
+func pgRestoreFile(ctx context.Context, container, database, path string, extra ...string) (int, []string, error) {
+	args := append([]string{"exec", container, "pg_restore", "-U", project.From(ctx).DBUser, "-d", database,
+		"-j", strconv.Itoa(SnapshotJobs)}, extra...)
+	args = append(args, path)
+	out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput()
+
+	var errs []string
+	count, completed := 0, false
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "pg_restore: error:") {
+			count++
+			if len(errs) < 5 {
+				errs = append(errs, truncateText(line, 200))
+			}
+		}
+		if strings.Contains(line, "errors ignored on restore") {
+			completed = true
+		}
+	}
+	if err != nil && !completed {
+		return count, errs, fmt.Errorf("pg_restore: %w: %s", err, lastLines(string(out), 3))
+	}
+	return count, errs, nil
+}
+
+// SelectiveRestore pulls some schemas or tables of a custom or directory
+// snapshot into a side schema of Database, next to the live data, for
+// comparison or a manual merge. Objects are "schema" or "schema.table".
+type SelectiveRestore struct {
+	Day        string   `json:"day"`
+	File       string   `json:"file"`
+	Objects    []string `json:"objects"`
+	Database   string   `json:"database,omitempty"`    // default postgres
+	Container  string   `json:"container,omitempty"`   // default the project's database container
+	SideSchema string   `json:"side_schema,omitempty"` // default restored_<day>_<HHMM>
+}
+
+type SelectiveRestoreResult struct {
+	Day         string            `json:"day"`
+	File        string            `json:"file"`
+	Target      string            `json:"target"`
+	Schemas     map[string]string `json:"schemas"` // source schema -> side schema
+	Entries     int               `json:"entries"` // TOC entries restored
+	Errors      int               `json:"errors"`
+	FirstErrors []string          `json:"first_errors,omitempty"`
+	Duration    time.Duration     `json:"duration_ns"`
+}
+
+// RestoreSnapshotObjects restores the selected objects into a scratch
+// database, renames their schemas there and copies the result into the target.
+// Foreign keys (and, for single tables, indexes) are left out: the copy is for
+// reading, not for taking over from the live tables.
+func RestoreSnapshotObjects(ctx context.Context, tg *telegram.Service, req SelectiveRestore) (res SelectiveRestoreResult, err error) {
+	res = SelectiveRestoreResult{Day: req.Day, File: req.File, Schemas: make(map[string]string)}
+	p := replica.Reader(ctx, project.From(ctx))
+	ctx = project.With(ctx, p)
+	if req.Database == "" {
+		req.Database = snapshotDatabase
+	}
+	if req.Container == "" {
+		req.Container = p.DBContainer
+	}
+	res.Target = req.Container + "/" + req.Database
+	if !identRe.MatchString(req.Database) || !containerRe.MatchString(req.Container) {
+		return res, fmt.Errorf("invalid target %s", res.Target)
+	}
+	format := api.SnapshotFormat(req.File)
+	if format == "plain" {
+		return res, fmt.Errorf("%s is a plain SQL dump, selective restore needs a custom or directory snapshot", req.File)
+	}
+	if len(req.Objects) == 0 {
+		return res, fmt.Errorf("no objects selected")
+	}
+
+	files, err := api.ListSnapshotFiles(p, req.Day)
+	if err != nil {
+		return res, fmt.Errorf("list snapshots of %s: %w", req.Day, err)
+	}
+	var snap *api.SnapshotFile
+	for i := range files {
+		if files[i].Filename == req.File {
+			snap = &files[i]
+		}
+	}
+	if snap == nil {
+		return res, fmt.Errorf("snapshot %s/%s not found", req.Day, req.File)
+	}
+	if req.SideSchema == "" {
+		req.SideSchema = "restored_" + strings.ReplaceAll(req.Day, "-", "") + "_" + snap.Time.Format("1504")
+	}
+
+	sel, err := parseSelection(req.Objects)
+	if err != nil {
+		return res, err
+	}
+	for schema := range sel {
+		side := req.SideSchema
+		if len(sel) > 1 {
+			side += "_" + schema
+		}
+		if !identRe.MatchString(side) {
+			return res, fmt.Errorf("invalid side schema %q", side)
+		}
+		res.Schemas[schema] = side
+		if out, err := psqlIn(ctx, req.Container, req.Database, "SELECT 1 FROM pg_namespace WHERE nspname = '"+side+"'"); err != nil || out == "1" {
+			return res, fmt.Errorf("side schema %s already exists in %s (or target unreachable: %v)", side, res.Target, err)
+		}
+	}
+
+	ctx, finish, err := begin(ctx, tg, "restore_snapshot")
+	if err != nil {
+		return res, err
+	}
+	defer func() { finish(err == nil) }()
+	start := time.Now()
+	log.Printf("♻️ [RESTORE] %s/%s objects %v → %s", req.Day, req.File, req.Objects, res.Target)
+
+	remote := p.SnapshotRemote(req.Day, req.File)
+	path, cleanup, err := stageDump(ctx, req.Container, remote, format)
+	if err != nil {
+		return res, err
+	}
+	defer cleanup()
+
+	toc, err := exec.CommandContext(ctx, "docker", "exec", req.Container, "pg_restore", "-l", path).Output()
+	if err != nil {
+		return res, fmt.Errorf("pg_restore -l: %w", err)
+	}
+	var list []string
+	for _, e := range api.ParseTOC(string(toc)) {
+		if sel.includes(e) {
+			list = append(list, e.Line)
+		}
+	}
+	if len(list) == 0 {
+		return res, fmt.Errorf("none of %v found in %s", req.Objects, req.File)
+	}
+	res.Entries = len(list)
+
+	listFile := path + ".list"
+	write := exec.CommandContext(ctx, "docker", "exec", "-i", req.Container, "sh", "-c", "cat > "+listFile)
+	write.Stdin = strings.NewReader(strings.Join(list, "\n") + "\n")
+	if out, err := write.CombinedOutput(); err != nil {
+		return res, fmt.Errorf("write restore list: %w: %s", err, out)
+	}
+	defer exec.Command("docker", "exec", req.Container, "rm", "-f", listFile).Run()
+
+	scratch := fmt.Sprintf("watchdog_side_%d", time.Now().Unix())
+	if out, err := psqlIn(ctx, req.Container, "postgres", "CREATE DATABASE "+scratch); err != nil {
+		return res, fmt.Errorf("create scratch database: %w: %s", err, out)
+	}
+	defer psqlIn(context.WithoutCancel(ctx), req.Container, "postgres", "DROP DATABASE IF EXISTS "+scratch+" WITH (FORCE)")
+
+	res.Errors, res.FirstErrors, err = pgRestoreFile(ctx, req.Container, scratch, path,
+		"-L", listFile, "--no-owner", "--no-privileges")
+	if err != nil {
+		return res, err
+	}
+	// pg_restore only counts failed statements; check the selection arrived.
+	missing, err := sel.missing(ctx, req.Container, scratch)
+	if err != nil {
+		return res, fmt.Errorf("check restored objects: %w", err)
+	}
+	if len(missing) > 0 {
+		tg.Send(fmt.Sprintf("❌ Selective restore of %s failed: %s not restored (%d statement errors)", req.File, strings.Join(missing, ", "), res.Errors))
+		err = fmt.Errorf("%s not restored from %s (%d statement errors: %s)", strings.Join(missing, ", "), req.File, res.Errors, strings.Join(res.FirstErrors, "; "))
+		return res, err
+	}
+
+	var renames, dumpArgs []string
+	for schema, side := range res.Schemas {
+		renames = append(renames, fmt.Sprintf(`ALTER SCHEMA "%s" RENAME TO "%s";`, schema, side))
+		dumpArgs = append(dumpArgs, "-n", side)
+	}
+	if out, err := psqlIn(ctx, req.Container, scratch, strings.Join(renames, " ")); err != nil {
+		return res, fmt.Errorf("rename schemas: %w: %s", err, out)
+	}
+
+	dump := exec.CommandContext(ctx, "docker", append([]string{"exec", req.Container, "pg_dump", "-U", p.DBUser,
+		"--no-owner", "--no-privileges"}, append(dumpArgs, scratch)...)...)
+	load := exec.CommandContext(ctx, "docker", "exec", "-i", req.Container, "psql", "-U", p.DBUser,
+		"-d", req.Database, "-q", "-v", "ON_ERROR_STOP=1")
+	if err = pipeCommands(dump, load); err != nil {
+		tg.Send(fmt.Sprintf("❌ Selective restore of %s into %s failed: %v", req.File, res.Target, err))
+		return res, fmt.Errorf("copy side schema: %w", err)
+	}
+	res.Duration = time.Since(start)
+
+	var moved []string
+	for schema, side := range res.Schemas {
+		moved = append(moved, schema+" → "+side)
+	}
+	msg := fmt.Sprintf("✅ *Selective restore done*\n• Snapshot: %s/%s\n• Objects: %s\n• Into: %s (%s)\n• Statement errors: %d\n• Duration: %s",
+		req.Day, req.File, strings.Join(req.Objects, ", "), res.Target, strings.Join(moved, ", "), res.Errors, res.Duration.Round(time.Second))
+	log.Println("✅ [RESTORE] " + strings.ReplaceAll(msg, "\n", " "))
+	tg.Send(msg)
+	return res, nil
+}
+
+// selection maps a schema to the tables wanted from it; nil means all of it.
+type selection map[string]map[string]bool
+
+func parseSelection(objects []string) (selection, error) {
+	sel := make(selection)
+	for _, o := range objects {
+		schema, table, hasTable := strings.Cut(o, ".")
+		if !identRe.MatchString(schema) || (hasTable && table == "") {
+			return nil, fmt.Errorf("invalid object %q, use schema or schema.table", o)
+		}
+		if !hasTable {
+			sel[schema] = nil
+			continue
+		}
+		if tables, seen := sel[schema]; seen && tables == nil {
+			continue // whole schema already selected
+		}
+		if sel[schema] == nil {
+			sel[schema] = make(map[string]bool)
+		}
+		sel[schema][table] = true
+	}
+	return sel, nil
+}
+
+// includes picks the TOC entries needed to rebuild the selection: whole
+// schemas, or the tables' definitions, data, defaults, constraints and
+// their serial sequences (<table>_..._seq by naming convention). The TOC
+// carries no dependencies, so a table selection also takes every type,
+// domain and function of its schema that a column, default or check may use.
+func (s selection) includes(e api.TOCEntry) bool {
+	if e.Type == "SCHEMA" {
+		_, ok := s[e.Name]
+		return ok && e.Name != "public" // every new database has public
+	}
+	tables, ok := s[e.Schema]
+	if !ok || e.Type == "FK CONSTRAINT" || e.Type == "ACL" || e.Type == "COMMENT" {
+		return false
+	}
+	if tables == nil {
+		return true
+	}
+	switch e.Type {
+	case "TYPE", "DOMAIN", "FUNCTION":
+		return true
+	case "TABLE", "TABLE DATA":
+		return tables[e.Name]
+	case "DEFAULT", "CONSTRAINT", "CHECK CONSTRAINT":
+		table, _, _ := strings.Cut(e.Name, " ")
+		return tables[table]
+	case "SEQUENCE", "SEQUENCE SET", "SEQUENCE OWNED BY":
+		for t := range tables {
+			if strings.HasPrefix(e.Name, t+"_") && strings.HasSuffix(e.Name, "_seq") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// missing lists the selected schemas and tables that do not exist in database.
+func (s selection) missing(ctx context.Context, container, database string) ([]string, error) {
+	var want []string
+	for schema, tables := range s {
+		if tables == nil {
+			want = append(want, fmt.Sprintf("SELECT '%s' WHERE to_regnamespace(quote_ident('%[1]s')) IS NULL", schema))
+			continue
+		}
+		for t := range tables {
+			t = strings.ReplaceAll(t, "'", "''")
+			want = append(want, fmt.Sprintf("SELECT '%s.%s' WHERE to_regclass(quote_ident('%[1]s') || '.' || quote_ident('%[2]s')) IS NULL", schema, t))
+		}
+	}
+	out, err := psqlIn(ctx, container, database, strings.Join(want, " UNION ALL "))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, out)
+	}
+	if out == "" {
+		return nil, nil
+	}
+	return strings.Split(out, "\n"), nil
+}
@@ -0,0 +1,182 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/lock"
+	"github.com/GoldenCarrotMLP/watchdog/internal/metrics"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// ShutdownGrace is how long in-flight tasks may keep running after the root
+// context is cancelled (SHUTDOWN_GRACE, keep below the compose stop_grace_period).
+var ShutdownGrace = func() time.Duration {
+	if d, err := time.ParseDuration(config.Env("SHUTDOWN_GRACE", "90s")); err == nil {
+		return d
+	}
+	return 90 * time.Second
+}()
+
+// Markers for tasks that were started but never finished live in each
+// project's state dir, so the next start can tell an interrupted run from a
+// completed one.
+const inflightDir = ".inflight"
+
+var inflight sync.WaitGroup
+
+type inflightMarker struct {
+	Task    string    `json:"task"`
+	Started time.Time `json:"started"`
+}
+
+// Default overlap policy per job; LOCK_POLICY_<JOB> overrides it.
+var lockPolicies = map[string]lock.Policy{
+	"snapshot":      lock.Skip,
+	"base_backup":   lock.Skip,
+	"restore_drill": lock.Skip,
+	"combine_base":  lock.Skip,
+	"verify_base":   lock.Queue,
+
+	"replica_parity": lock.Skip,
+
+	"restore_snapshot": lock.Skip,
+}
+
+// begin takes the job's lock and registers it as running. The returned context
+// survives cancellation of ctx for ShutdownGrace so uploads can finish;
+// finish(true) clears the incomplete marker, finish(false) leaves it for the
+// next start. If the job is already running (here or on another instance) the
+// run is skipped or queued according to its policy, and reported. Locks are
+// per project, so dev and main snapshots do not block each other.
+func begin(ctx context.Context, tg *telegram.Service, task string) (context.Context, func(ok bool), error) {
+	p := project.From(ctx)
+	name := task
+	if strings.HasPrefix(task, "archive_") {
+		name = "archive"
+	}
+	policy := lock.PolicyFor(name, lockPolicies[name])
+
+	waitStart := time.Now()
+	release, err := lock.Acquire(ctx, p.Key(task), policy)
+	if err != nil {
+		var busy *lock.ErrBusy
+		if errors.As(err, &busy) {
+			metrics.JobOverlaps.WithLabelValues(p.Name, name, "skipped").Inc()
+			log.Printf("⏭️ [%s] Skipped: %v", strings.ToUpper(name), err)
+			tg.Send(fmt.Sprintf("⏭️ Skipped %s: %v", task, err))
+		}
+		return ctx, nil, err
+	}
+	if waited := time.Since(waitStart); waited > time.Second {
+		metrics.JobOverlaps.WithLabelValues(p.Name, name, "queued").Inc()
+		tg.Send(fmt.Sprintf("⏳ %s was queued behind a running instance for %s", task, waited.Round(time.Second)))
+	}
+
+	inflight.Add(1)
+
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		log.Printf("⏳ [%s] Shutdown requested, %s grace to finish", strings.ToUpper(task), ShutdownGrace)
+		time.AfterFunc(ShutdownGrace, cancel)
+	})
+
+	dir := p.State(inflightDir)
+	marker := filepath.Join(dir, task+".json")
+	os.MkdirAll(dir, 0755)
+	data, _ := json.Marshal(inflightMarker{Task: task, Started: time.Now()})
+	os.WriteFile(marker, data, 0644)
+
+	return work, func(ok bool) {
+		ok = ok && work.Err() == nil
+		stop()
+		cancel()
+		if ok {
+			os.Remove(marker)
+		}
+		release()
+		inflight.Done()
+	}, nil
+}
+
+// Wait blocks until every running task returned or ctx expires.
+func Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ResumeIncomplete handles the markers that survived the previous shutdown:
+// archives and the base backup are re-run in the background, the snapshot is
+// left to the startup snapshot, and every other task is reported as not
+// resumed. It returns the tasks it re-runs so the caller does not start them
+// twice.
+func ResumeIncomplete(ctx context.Context, tg *telegram.Service) []string {
+	dir := project.From(ctx).State(inflightDir)
+	entries, _ := os.ReadDir(dir)
+	var resumed, archives []string
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var m inflightMarker
+		if json.Unmarshal(data, &m) != nil {
+			os.Remove(path)
+			continue
+		}
+		started := m.Started.Format(time.RFC3339)
+
+		switch {
+		case strings.HasPrefix(m.Task, "archive_"):
+			// The marker stays until the re-run succeeds.
+			archives = append(archives, strings.TrimPrefix(m.Task, "archive_"))
+		case m.Task == "base_backup":
+			os.Remove(path)
+		case m.Task == "snapshot":
+			// Taken again at every start anyway.
+			os.Remove(path)
+			log.Printf("♻️ [RESUME] snapshot was interrupted (started %s), the startup snapshot re-takes it", started)
+			tg.Send("♻️ Interrupted snapshot is re-taken by the startup snapshot")
+			continue
+		default:
+			os.Remove(path)
+			log.Printf("⚠️ [RESUME] %s was interrupted (started %s), not resumed", m.Task, started)
+			tg.Send(fmt.Sprintf("⚠️ %s was interrupted (started %s) and is not resumed; run it again if still needed", m.Task, started))
+			continue
+		}
+		resumed = append(resumed, m.Task)
+		log.Printf("♻️ [RESUME] %s was interrupted (started %s), resuming", m.Task, started)
+		tg.Send("♻️ Resuming interrupted task: " + m.Task)
+	}
+
+	go func() {
+		for _, date := range archives {
+			ArchiveRemoteDay(ctx, date, tg)
+		}
+	}()
+	if slices.Contains(resumed, "base_backup") {
+		go CheckAndRunStartupBaseBackup(ctx, tg)
+	}
+	return resumed
+}
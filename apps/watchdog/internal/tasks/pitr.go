@@ -1,63 +1,97 @@
 package tasks
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
-	"fmt"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
-func StartWALUploader(tg *telegram.Service) {
-	walDir := "/wal_archive"
-	remoteRoot := "dropbox:SupabaseServerBackups_WAL"
+// StartWALUploader ships the project's WAL directory to its remote every 10s.
+func StartWALUploader(ctx context.Context, tg *telegram.Service) {
+	p := project.From(ctx)
+	walDir := p.WALDir
+	remoteRoot := p.WALRemote()
 
 	log.Println("🚀 [PITR] WAL Uploader started, watching:", walDir)
 
+	inflight.Add(1)
 	go func() {
+		defer inflight.Done()
 		ticker := time.NewTicker(10 * time.Second)
-		for range ticker.C {
-			files, err := os.ReadDir(walDir)
-			if err != nil {
-				continue
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				// Flush whatever Postgres archived last before we exit. Anything
+				// not uploaded stays in walDir and is picked up on the next start.
+				flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ShutdownGrace)
+				uploadWALBatch(flushCtx, walDir, remoteRoot)
+				cancel()
+				log.Println("🛑 [PITR] WAL Uploader stopped")
+				return
+			case <-ticker.C:
+				uploadWALBatch(ctx, walDir, remoteRoot)
 			}
+		}
+	}()
+}
 
-			// Filter out empty directories and hidden files (like .DS_Store)
-			var validFiles []os.DirEntry
-			for _, f := range files {
-				if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
-					validFiles = append(validFiles, f)
-				}
-			}
+func uploadWALBatch(ctx context.Context, walDir, remoteRoot string) {
+	files, err := os.ReadDir(walDir)
+	if err != nil {
+		return
+	}
 
-			if len(validFiles) == 0 {
-				continue
-			}
+	// Filter out empty directories and hidden files (like .DS_Store)
+	var validFiles []os.DirEntry
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") {
+			validFiles = append(validFiles, f)
+		}
+	}
 
-			dateDir := time.Now().Format("2006-01-02")
-			remotePath := fmt.Sprintf("%s/%s/WAL", remoteRoot, dateDir)
+	if len(validFiles) == 0 {
+		return
+	}
 
-			log.Printf("📦 [PITR] Found %d new files (WAL/Metadata), uploading...", len(validFiles))
+	dateDir := time.Now().Format("2006-01-02")
+	remotePath := fmt.Sprintf("%s/%s/WAL", remoteRoot, dateDir)
 
-			// Use 'copy' to be safe. It will create the remote directory if missing.
-			cmd := exec.Command("docker", "exec", "supabase-rclone", "rclone", 
-				"copy", walDir+"/", remotePath)
-			
-			if err := cmd.Run(); err != nil {
-				log.Printf("❌ [PITR] Upload failed: %v", err)
-				continue
-			}
+	log.Printf("📦 [PITR] Found %d new files (WAL/Metadata), uploading...", len(validFiles))
 
-			// ONLY delete local files that were there when we started the upload
-			// to avoid deleting a file that Postgres just finished writing 1ms ago
-			for _, f := range validFiles {
-				fullPath := filepath.Join(walDir, f.Name())
-				os.Remove(fullPath)
-			}
-			log.Println("✅ [PITR] Batch uploaded and local storage cleared")
-		}
-	}()
-}
\ No newline at end of file
+	// Use 'copy' to be safe. It will create the remote directory if missing.
+	cmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone",
+		"copy", walDir+"/", remotePath)
+
+	if err := cmd.Run(); err != nil {
+		log.Printf("❌ [PITR] Upload failed: %v", err)
+		return
+	}
+	// Replicas get the same files from local disk; one that misses them is
+	// repaired from the primary by the parity check.
+	replica.Upload(ctx, project.From(ctx), walDir+"/", remotePath)
+
+	names := make([]string, len(validFiles))
+	for i, f := range validFiles {
+		names[i] = f.Name()
+	}
+	project.From(ctx).ConfirmWAL(names)
+
+	// ONLY delete local files that were there when we started the upload
+	// to avoid deleting a file that Postgres just finished writing 1ms ago.
+	// Deletion is not interruptible: once the copy succeeded we always finish it.
+	for _, f := range validFiles {
+		fullPath := filepath.Join(walDir, f.Name())
+		os.Remove(fullPath)
+	}
+	log.Println("✅ [PITR] Batch uploaded and local storage cleared")
+}
@@ -0,0 +1,26 @@
+package tasks
+
+import "context"
+
+// Progress is a snapshot of how far a long-running task has got.
+type Progress struct {
+	Task    string `json:"task"`
+	Percent int    `json:"percent"`
+	Done    int64  `json:"done_bytes"`
+	Total   int64  `json:"total_bytes,omitempty"`
+	Sent    int64  `json:"sent_bytes,omitempty"` // bytes handed to the uploader
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context whose tasks report their progress to fn.
+// The job consumer uses it to expose progress in watchdog.job_status.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func reportProgress(ctx context.Context, p Progress) {
+	if fn, ok := ctx.Value(progressKey{}).(func(Progress)); ok {
+		fn(p)
+	}
+}
@@ -0,0 +1,83 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/metrics"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// RecoveryRPOTarget is the worst data loss we accept; the nightly report
+// is flagged when any gap exceeds it (RECOVERY_RPO_TARGET).
+var RecoveryRPOTarget = func() time.Duration {
+	if d, err := time.ParseDuration(config.Env("RECOVERY_RPO_TARGET", "24h")); err == nil && d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}()
+
+// RunRecoveryReport computes what we can restore to over the last
+// RECOVERY_REPORT_DAYS, exports it as metrics and posts it to Telegram.
+func RunRecoveryReport(ctx context.Context, tg *telegram.Service) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p := project.From(ctx)
+	log.Printf("🧮 [RECOVERY] Building recoverability report of %s for the last %d days...", p.Name, api.RecoveryReportDays)
+	rep := api.BuildRecoveryReport(p, api.RecoveryReportDays)
+
+	metrics.RecoveryMaxRPO.WithLabelValues(p.Name).Set(rep.MaxRPO.Seconds())
+	metrics.RecoveryCurrentRPO.WithLabelValues(p.Name).Set(rep.CurrentRPO.Seconds())
+	metrics.RecoveryGaps.WithLabelValues(p.Name).Set(float64(len(rep.Gaps)))
+	metrics.RecoveryCoverage.WithLabelValues(p.Name).Set(rep.Coverage)
+
+	log.Printf("🧮 [RECOVERY] %d intervals, %d gaps, max RPO %s, current RPO %s, %.1f%% covered",
+		len(rep.Intervals), len(rep.Gaps), roundDuration(rep.MaxRPO), roundDuration(rep.CurrentRPO), rep.Coverage*100)
+	tg.Send(formatRecoveryReport(rep))
+	return nil
+}
+
+func formatRecoveryReport(rep api.RecoveryReport) string {
+	icon := "✅"
+	if rep.MaxRPO > RecoveryRPOTarget || rep.CurrentRPO > RecoveryRPOTarget {
+		icon = "⚠️"
+	}
+	msg := fmt.Sprintf("%s *Recoverability, last %d days*\nMax RPO: %s (target %s)\nCurrent RPO: %s\nPITR coverage: %.1f%%",
+		icon, rep.Days, roundDuration(rep.MaxRPO), RecoveryRPOTarget, roundDuration(rep.CurrentRPO), rep.Coverage*100)
+
+	var rows []string
+	for _, g := range rep.Gaps {
+		if g.Duration < time.Hour {
+			continue // snapshots every 30 minutes leave small holes by design
+		}
+		rows = append(rows, fmt.Sprintf("%s → %s  %s", g.Start.Format("01-02 15:04"), g.End.Format("01-02 15:04"), roundDuration(g.Duration)))
+	}
+	if len(rows) > 0 {
+		if len(rows) > 30 {
+			rows = append(rows[:30], fmt.Sprintf("… %d more", len(rows)-30))
+		}
+		msg += "\nGaps over 1h (UTC):\n```\n" + strings.Join(rows, "\n") + "\n```"
+	}
+	for i, p := range rep.Problems {
+		if i == 10 {
+			msg += fmt.Sprintf("\n… %d more problems", len(rep.Problems)-10)
+			break
+		}
+		msg += "\n• " + truncateText(p, 120)
+	}
+	return msg
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Hour {
+		return d.Round(time.Minute)
+	}
+	return d.Round(time.Second)
+}
@@ -0,0 +1,82 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// RunReplicaParity compares the project's destinations, repairs missing or
+// differing copies and reports on Telegram when anything was off.
+func RunReplicaParity(ctx context.Context, tg *telegram.Service) (err error) {
+	p := project.From(ctx)
+	if len(p.Mirrors()) == 0 {
+		log.Printf("🔁 [PARITY] %s has a single destination, nothing to compare", p.Name)
+		return nil
+	}
+	ctx, finish, err := begin(ctx, tg, "replica_parity")
+	if err != nil {
+		return err
+	}
+	defer func() { finish(err == nil) }()
+
+	report, err := replica.Check(ctx, p, true)
+	if err != nil {
+		tg.Send(fmt.Sprintf("🚨 *Replica parity check failed*\n%v", err))
+		return err
+	}
+	if len(report.Problems) > 0 || !report.OK() {
+		tg.Send(formatParityReport(report))
+	}
+	if !report.OK() {
+		left, unlisted := unrepaired(report)
+		return fmt.Errorf("%d problems left unrepaired, %d destinations not listed", left, unlisted)
+	}
+	return nil
+}
+
+func formatParityReport(r replica.ParityReport) string {
+	icon := "🩹"
+	if !r.OK() {
+		icon = "⚠️"
+	}
+	msg := fmt.Sprintf("%s *Replica parity*: %d files, %d problems", icon, r.Files, len(r.Problems))
+	for _, d := range r.Destinations {
+		line := fmt.Sprintf("\n• %s (%s): %d files", d.Name, d.Role, d.Files)
+		switch {
+		case d.Error != "":
+			line += ", not listed: " + truncateText(d.Error, 120)
+		case d.Problems > 0:
+			line += fmt.Sprintf(", %d problems, %d repaired", d.Problems, d.Repaired)
+		}
+		msg += line
+	}
+	var rows []string
+	for _, pr := range r.Problems {
+		if !pr.Repaired {
+			rows = append(rows, fmt.Sprintf("%s on %s: %s", pr.Issue, pr.Destination, pr.Path))
+		}
+	}
+	if len(rows) > 5 {
+		rows = append(rows[:5], fmt.Sprintf("... and %d more", len(rows)-5))
+	}
+	if len(rows) > 0 {
+		msg += "\nUnrepaired:\n" + strings.Join(rows, "\n")
+	}
+	return msg
+}
+
+func unrepaired(r replica.ParityReport) (left, unlisted int) {
+	for _, d := range r.Destinations {
+		left += d.Problems - d.Repaired
+		if d.Error != "" {
+			unlisted++
+		}
+	}
+	return left, unlisted
+}
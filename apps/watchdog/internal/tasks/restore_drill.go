@@ -0,0 +1,96 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+const drillDatabase = "watchdog_restore_drill"
+
+// RunRestoreDrill restores the newest logical snapshot into a scratch database
+// inside the project's database container, checks that tables came back and
+// drops it again.
+func RunRestoreDrill(ctx context.Context, tg *telegram.Service) (err error) {
+	ctx, finish, err := begin(ctx, tg, "restore_drill")
+	if err != nil {
+		return err
+	}
+	defer func() { finish(err == nil) }()
+	start := time.Now()
+	p := project.From(ctx)
+
+	day, snap, err := latestSnapshot(p)
+	if err != nil {
+		tg.Send(fmt.Sprintf("❌ Restore drill failed: %v", err))
+		return err
+	}
+	file := snap.Filename
+	log.Printf("🧪 [DRILL] Restoring %s/%s into %s...", day, file, drillDatabase)
+
+	psql := func(dbName, sql string) (string, error) {
+		out, err := exec.CommandContext(ctx, "docker", "exec", p.DBContainer, "psql", "-U", p.DBUser, "-d", dbName, "-Atc", sql).CombinedOutput()
+		return strings.TrimSpace(string(out)), err
+	}
+	defer exec.Command("docker", "exec", p.DBContainer, "psql", "-U", p.DBUser, "-d", "postgres", "-Atc", "DROP DATABASE IF EXISTS "+drillDatabase+" WITH (FORCE)").Run()
+
+	psql("postgres", "DROP DATABASE IF EXISTS "+drillDatabase+" WITH (FORCE)")
+	if out, err := psql("postgres", "CREATE DATABASE "+drillDatabase); err != nil {
+		tg.Send(fmt.Sprintf("❌ Restore drill failed: %s", out))
+		return fmt.Errorf("create database: %w", err)
+	}
+
+	// Dumps reference Supabase roles and extensions that may not exist in a
+	// fresh database, so statement errors are tolerated and counted instead.
+	res := SnapshotRestoreResult{Day: day, File: file, Container: p.DBContainer, Database: drillDatabase}
+	remote := p.SnapshotRemote(day, file)
+	if snap.Format == "directory" {
+		err = restoreDirectory(ctx, remote, &res)
+	} else {
+		err = streamRestore(ctx, remote, snap.Size, &res)
+	}
+	if err != nil {
+		tg.Send("❌ Restore drill failed while replaying the snapshot.")
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	tables, err := psql(drillDatabase, "SELECT count(*) FROM information_schema.tables WHERE table_schema = 'public'")
+	if err != nil || tables == "0" {
+		tg.Send(fmt.Sprintf("❌ Restore drill: %s restored no public tables.", file))
+		return fmt.Errorf("no tables restored from %s", file)
+	}
+
+	msg := fmt.Sprintf("🧪 *Restore drill passed*\n• Snapshot: %s\n• Public tables: %s\n• Statement errors: %d\n• Duration: %s",
+		file, tables, res.Errors, time.Since(start).Round(time.Second))
+	log.Println("✅ [DRILL] " + strings.ReplaceAll(msg, "\n", " "))
+	tg.Send(msg)
+	return nil
+}
+
+// latestSnapshot returns the newest snapshot of the application database.
+func latestSnapshot(p *project.Project) (string, api.SnapshotFile, error) {
+	days, _ := api.ListSnapshotDays(p)
+	for _, d := range days {
+		files, _ := api.ListSnapshotFiles(p, d.Date)
+		var main []api.SnapshotFile
+		for _, f := range files {
+			if f.Database == snapshotDatabase {
+				main = append(main, f)
+			}
+		}
+		if len(main) == 0 {
+			continue
+		}
+		sort.Slice(main, func(i, j int) bool { return main[i].Time.After(main[j].Time) })
+		return d.Date, main[0], nil
+	}
+	return "", api.SnapshotFile{}, fmt.Errorf("no snapshots found on Dropbox")
+}
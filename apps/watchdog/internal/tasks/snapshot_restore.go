@@ -0,0 +1,376 @@
+package tasks
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// SnapshotRestore asks for one logical snapshot to be replayed into a
+// database. Database defaults to a fresh restore_<day>_<HHMM>; Container
+// defaults to the project's database container, or names a staging one.
+// Overwriting a database that already exists in a project's container needs
+// Confirm, a token the first, unconfirmed attempt posts to Telegram.
+type SnapshotRestore struct {
+	Day       string `json:"day"`
+	File      string `json:"file"`
+	Database  string `json:"database,omitempty"`
+	Container string `json:"container,omitempty"`
+	Confirm   string `json:"confirm,omitempty"`
+}
+
+// SnapshotRestoreResult is what the API and CLI report back.
+type SnapshotRestoreResult struct {
+	Day            string        `json:"day"`
+	File           string        `json:"file"`
+	Container      string        `json:"container"`
+	Database       string        `json:"database"`
+	Created        bool          `json:"created"`                   // target did not exist before
+	SafetySnapshot string        `json:"safety_snapshot,omitempty"` // remote dump of the target taken first
+	Format         string        `json:"format"`                    // plain (psql), custom or directory (pg_restore)
+	Errors         int           `json:"errors"`
+	FirstErrors    []string      `json:"first_errors,omitempty"`
+	Duration       time.Duration `json:"duration_ns"`
+}
+
+// ConfirmationRequired is returned by a first attempt to overwrite an
+// existing database. Error() leaves the token out: it would end up in
+// watchdog.job_status or an API response, readable by any client. The token
+// goes to Telegram, and the CLI prints it.
+type ConfirmationRequired struct {
+	Target string
+	Token  string
+}
+
+func (e *ConfirmationRequired) Error() string {
+	return fmt.Sprintf("restoring into existing database %s needs confirmation: repeat with the token sent to Telegram within %s", e.Target, restoreTokenTTL)
+}
+
+var (
+	identRe     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+	containerRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// RestoreSnapshot takes a safety snapshot of the target if it exists, then
+// streams the snapshot from remote storage through decompression into psql
+// (plain dumps) or pg_restore (custom-format dumps) inside the container.
+// Directory snapshots are staged in the container and restored in parallel.
+func RestoreSnapshot(ctx context.Context, tg *telegram.Service, req SnapshotRestore) (res SnapshotRestoreResult, err error) {
+	res = SnapshotRestoreResult{Day: req.Day, File: req.File, Container: req.Container, Database: req.Database}
+	// An unreachable primary falls back to a replica, safety snapshot included.
+	p := replica.Reader(ctx, project.From(ctx))
+	ctx = project.With(ctx, p)
+	if res.Container == "" {
+		res.Container = p.DBContainer
+	}
+	if !containerRe.MatchString(res.Container) {
+		return res, fmt.Errorf("invalid container %q", res.Container)
+	}
+
+	files, _ := api.ListSnapshotFiles(p, req.Day)
+	var snap *api.SnapshotFile
+	for i := range files {
+		if files[i].Filename == req.File {
+			snap = &files[i]
+		}
+	}
+	if snap == nil {
+		return res, fmt.Errorf("snapshot %s/%s not found", req.Day, req.File)
+	}
+	if res.Database == "" {
+		res.Database = "restore_" + strings.ReplaceAll(req.Day, "-", "") + "_" + snap.Time.Format("1504")
+	}
+	if !identRe.MatchString(res.Database) {
+		return res, fmt.Errorf("invalid database name %q", res.Database)
+	}
+
+	exists, err := databaseExists(ctx, res.Container, res.Database)
+	if err != nil {
+		return res, err
+	}
+	res.Created = !exists
+
+	target := res.Container + "/" + res.Database
+	if exists && isProjectContainer(res.Container) {
+		if req.Confirm == "" {
+			token := issueRestoreToken(target, req.Day+"/"+req.File)
+			tg.Send(fmt.Sprintf("🔐 Restoring %s/%s into existing database %s needs confirmation.\nRepeat with confirm=`%s` within %s.", req.Day, req.File, target, token, restoreTokenTTL))
+			return res, &ConfirmationRequired{Target: target, Token: token}
+		}
+		if !consumeRestoreToken(req.Confirm, target, req.Day+"/"+req.File) {
+			return res, fmt.Errorf("confirmation token invalid or expired for %s", target)
+		}
+	}
+
+	ctx, finish, err := begin(ctx, tg, "restore_snapshot")
+	if err != nil {
+		return res, err
+	}
+	defer func() { finish(err == nil) }()
+	start := time.Now()
+
+	log.Printf("♻️ [RESTORE] %s/%s → %s", req.Day, req.File, target)
+	tg.Send(fmt.Sprintf("♻️ Restoring snapshot %s into %s...", req.File, target))
+
+	if exists {
+		if res.SafetySnapshot, err = takeSafetySnapshot(ctx, res.Container, res.Database); err != nil {
+			tg.Send(fmt.Sprintf("❌ Restore into %s aborted: safety snapshot failed: %v", target, err))
+			return res, fmt.Errorf("safety snapshot: %w", err)
+		}
+		log.Printf("🛟 [RESTORE] Safety snapshot of %s at %s", target, res.SafetySnapshot)
+	} else if out, err := psqlIn(ctx, res.Container, "postgres", `CREATE DATABASE "`+res.Database+`"`); err != nil {
+		return res, fmt.Errorf("create database: %w: %s", err, out)
+	}
+
+	remote := p.SnapshotRemote(req.Day, req.File)
+	if snap.Format == "directory" {
+		err = restoreDirectory(ctx, remote, &res)
+	} else {
+		err = streamRestore(ctx, remote, snap.Size, &res)
+	}
+	if err != nil {
+		tg.Send(fmt.Sprintf("❌ Restore of %s into %s failed: %v", req.File, target, err))
+		return res, err
+	}
+	res.Duration = time.Since(start)
+
+	msg := fmt.Sprintf("✅ *Snapshot restored*\n• Snapshot: %s/%s\n• Target: %s (%s)\n• Statement errors: %d\n• Duration: %s",
+		req.Day, req.File, target, res.Format, res.Errors, res.Duration.Round(time.Second))
+	if res.SafetySnapshot != "" {
+		msg += "\n• Safety snapshot: " + res.SafetySnapshot
+	}
+	log.Println("✅ [RESTORE] " + strings.ReplaceAll(msg, "\n", " "))
+	tg.Send(msg)
+	return res, nil
+}
+
+// streamRestore pipes `rclone cat` through gunzip into the restore client,
+// reporting progress on the compressed bytes read.
+func streamRestore(ctx context.Context, remote string, size int64, res *SnapshotRestoreResult) error {
+	src := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remote)
+	stdout, err := src.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := src.Start(); err != nil {
+		return fmt.Errorf("rclone cat: %w", err)
+	}
+	srcDone := false
+	defer func() {
+		if !srcDone {
+			src.Process.Kill()
+			src.Wait()
+		}
+	}()
+
+	read := &countingWriter{w: io.Discard}
+	stopProgress := make(chan struct{})
+	defer close(stopProgress)
+	go func() {
+		t := time.NewTicker(5 * time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-stopProgress:
+				return
+			case <-t.C:
+				p := Progress{Task: "restore_snapshot", Done: read.n.Load(), Total: size}
+				if size > 0 {
+					p.Percent = int(100 * p.Done / size)
+				}
+				reportProgress(ctx, p)
+			}
+		}
+	}()
+
+	br := bufio.NewReader(io.TeeReader(stdout, read))
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("gunzip: %w", err)
+		}
+		br = bufio.NewReader(gz)
+	}
+
+	// Custom-format archives start with PGDMP and go through pg_restore;
+	// everything else is a plain script for psql.
+	res.Format = "plain"
+	user := project.From(ctx).DBUser
+	client := "psql"
+	args := []string{"exec", "-i", res.Container, client, "-U", user, "-d", res.Database, "-q"}
+	if magic, _ := br.Peek(5); string(magic) == "PGDMP" {
+		res.Format, client = "custom", "pg_restore"
+		args = []string{"exec", "-i", res.Container, client, "-U", user, "-d", res.Database, "--clean", "--if-exists"}
+	}
+
+	restore := exec.CommandContext(ctx, "docker", args...)
+	restore.Stdin = br
+	stderr, err := restore.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := restore.Start(); err != nil {
+		return err
+	}
+
+	// Plain dumps reference Supabase roles and extensions that may not exist
+	// in the target, so statement errors are counted rather than fatal.
+	// pg_restore then exits 1 after "errors ignored on restore"; without that
+	// line it stopped early (bad archive, lost connection).
+	completed := false
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.Contains(line, "errors ignored on restore") {
+			completed = true
+		}
+		if !strings.Contains(line, "ERROR") && !strings.HasPrefix(line, "pg_restore: error:") {
+			continue
+		}
+		res.Errors++
+		if len(res.FirstErrors) < 5 {
+			res.FirstErrors = append(res.FirstErrors, truncateText(line, 200))
+		}
+	}
+	restoreErr := restore.Wait()
+	if restoreErr != nil && (res.Format == "plain" || !completed) {
+		// Stop the download rather than fetching the rest of the snapshot;
+		// the deferred kill does that.
+		if len(res.FirstErrors) > 0 {
+			return fmt.Errorf("%s: %w: %s", client, restoreErr, res.FirstErrors[len(res.FirstErrors)-1])
+		}
+		return fmt.Errorf("%s: %w", client, restoreErr)
+	}
+	io.Copy(io.Discard, stdout)
+
+	srcDone = true
+	if err := src.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("download interrupted: %w", err)
+	}
+	reportProgress(ctx, Progress{Task: "restore_snapshot", Percent: 100, Done: read.n.Load(), Total: size})
+	return nil
+}
+
+// restoreDirectory stages a directory snapshot in the container and replays
+// it with SNAPSHOT_JOBS parallel pg_restore workers.
+func restoreDirectory(ctx context.Context, remote string, res *SnapshotRestoreResult) error {
+	res.Format = "directory"
+	path, cleanup, err := stageDump(ctx, res.Container, remote, "directory")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	res.Errors, res.FirstErrors, err = pgRestoreFile(ctx, res.Container, res.Database, path, "--clean", "--if-exists")
+	return err
+}
+
+// takeSafetySnapshot dumps the target to <today>/pre-restore/ before it is overwritten.
+func takeSafetySnapshot(ctx context.Context, container, database string) (string, error) {
+	now := time.Now()
+	p := project.From(ctx)
+	remote := p.SnapshotRemote(now.Format("2006-01-02"), "pre-restore",
+		fmt.Sprintf("supabase-%s-%s_%s.sql.gz", database, now.Format("2006-01-02"), now.Format("15-04-PM")))
+	dump := exec.CommandContext(ctx, "docker", "exec", container,
+		"pg_dump", "-U", p.DBUser, "--clean", "--if-exists", "-Z", "6", database)
+	if _, err := streamUpload(ctx, dump, remote, nil, nil); err != nil {
+		return "", err
+	}
+	return remote, nil
+}
+
+func databaseExists(ctx context.Context, container, database string) (bool, error) {
+	out, err := psqlIn(ctx, container, "postgres", "SELECT 1 FROM pg_database WHERE datname = '"+database+"'")
+	if err != nil {
+		return false, fmt.Errorf("check %s on %s: %w: %s", database, container, err, out)
+	}
+	return out == "1", nil
+}
+
+func psqlIn(ctx context.Context, container, database, sql string) (string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "exec", container, "psql", "-U", project.From(ctx).DBUser, "-d", database, "-Atc", sql).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// isProjectContainer reports whether container is the live database of any
+// project; overwriting a database there needs a confirmation token.
+func isProjectContainer(container string) bool {
+	for _, p := range project.All() {
+		if p.DBContainer == container {
+			return true
+		}
+	}
+	return false
+}
+
+// Confirmation tokens live on disk, in the main project's state dir, so the
+// CLI (docker exec) and the API process see the same ones.
+const (
+	restoreTokensFile = ".restore_tokens.json"
+	restoreTokenTTL   = 10 * time.Minute
+)
+
+type restoreToken struct {
+	Target   string    `json:"target"`
+	Snapshot string    `json:"snapshot"`
+	Expires  time.Time `json:"expires"`
+}
+
+var restoreTokensMu sync.Mutex
+
+func issueRestoreToken(target, snapshot string) string {
+	restoreTokensMu.Lock()
+	defer restoreTokensMu.Unlock()
+	b := make([]byte, 4)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	tokens := loadRestoreTokens()
+	tokens[token] = restoreToken{Target: target, Snapshot: snapshot, Expires: time.Now().Add(restoreTokenTTL)}
+	saveRestoreTokens(tokens)
+	return token
+}
+
+func consumeRestoreToken(token, target, snapshot string) bool {
+	restoreTokensMu.Lock()
+	defer restoreTokensMu.Unlock()
+	tokens := loadRestoreTokens()
+	t, ok := tokens[token]
+	delete(tokens, token)
+	saveRestoreTokens(tokens)
+	return ok && t.Target == target && t.Snapshot == snapshot && time.Now().Before(t.Expires)
+}
+
+func loadRestoreTokens() map[string]restoreToken {
+	tokens := make(map[string]restoreToken)
+	if data, err := os.ReadFile(project.Default().State(restoreTokensFile)); err == nil {
+		json.Unmarshal(data, &tokens)
+	}
+	for k, t := range tokens {
+		if time.Now().After(t.Expires) {
+			delete(tokens, k)
+		}
+	}
+	return tokens
+}
+
+func saveRestoreTokens(tokens map[string]restoreToken) {
+	data, _ := json.Marshal(tokens)
+	os.WriteFile(project.Default().State(restoreTokensFile), data, 0600)
+}
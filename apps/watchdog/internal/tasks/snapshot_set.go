@@ -0,0 +1,141 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/replica"
+)
+
+// SnapshotExclude lists database name patterns left out of snapshot sets
+// (SNAPSHOT_EXCLUDE_DATABASES, comma separated); scratch databases of
+// restores and drills are excluded by default.
+var SnapshotExclude = strings.Split(config.Env("SNAPSHOT_EXCLUDE_DATABASES", "watchdog_*,restore_*"), ",")
+
+// SnapshotGlobalsPasswords keeps role password hashes in the globals dump
+// (SNAPSHOT_GLOBALS_PASSWORDS=include). By default they are left out, and
+// restored roles need their passwords set again.
+var SnapshotGlobalsPasswords = config.Env("SNAPSHOT_GLOBALS_PASSWORDS", "exclude") == "include"
+
+// takeSnapshotSet dumps every non-template database plus the cluster globals
+// and publishes a manifest that ties the files together. A failing database
+// does not stop the others; the set is then marked incomplete.
+func takeSnapshotSet(ctx context.Context) (api.SnapshotSet, error) {
+	now := time.Now()
+	stamp := now.Format("15-04-PM")
+	set := api.SnapshotSet{Name: "snapshot-set_" + stamp, Day: now.Format("2006-01-02"), Started: now,
+		Format: SnapshotFormat, GlobalsPasswords: SnapshotGlobalsPasswords}
+
+	databases, err := snapshotDatabases(ctx)
+	if err != nil {
+		return set, err
+	}
+	for _, database := range databases {
+		f, err := dumpDatabase(ctx, SnapshotFormat, database, set.Day, stamp)
+		if err != nil {
+			log.Printf("❌ [BACKUP] %s: %v", database, err)
+			set.Errors = append(set.Errors, fmt.Sprintf("%s: %v", database, err))
+			continue
+		}
+		set.Databases = append(set.Databases, f)
+	}
+
+	if g, err := dumpGlobals(ctx, set.Day, stamp); err != nil {
+		log.Printf("❌ [BACKUP] globals: %v", err)
+		set.Errors = append(set.Errors, fmt.Sprintf("globals: %v", err))
+	} else {
+		set.Globals = &g
+	}
+
+	set.Finished = time.Now()
+	set.Complete = len(set.Errors) == 0
+	data, _ := json.MarshalIndent(set, "", "  ")
+	remote := project.From(ctx).SnapshotRemote(set.Day, set.Name+".json")
+	if err := rcatFrom(ctx, strings.NewReader(string(data)), remote); err != nil {
+		return set, fmt.Errorf("upload manifest: %w", err)
+	}
+	if !set.Complete {
+		return set, fmt.Errorf("%d of %d dumps failed: %s", len(set.Errors), len(databases)+1, strings.Join(set.Errors, "; "))
+	}
+	return set, nil
+}
+
+// snapshotDatabases lists the databases a snapshot set covers, postgres first.
+func snapshotDatabases(ctx context.Context) ([]string, error) {
+	out, err := psqlIn(ctx, project.From(ctx).DBContainer, "postgres",
+		"SELECT datname FROM pg_database WHERE NOT datistemplate AND datallowconn ORDER BY datname <> 'postgres', datname")
+	if err != nil {
+		return nil, fmt.Errorf("list databases: %w: %s", err, out)
+	}
+	var dbs []string
+	for _, name := range strings.Split(out, "\n") {
+		if name = strings.TrimSpace(name); name != "" && !snapshotExcluded(name) {
+			dbs = append(dbs, name)
+		}
+	}
+	return dbs, nil
+}
+
+func snapshotExcluded(name string) bool {
+	for _, pattern := range SnapshotExclude {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpGlobals uploads roles, memberships and tablespaces as gzipped SQL.
+func dumpGlobals(ctx context.Context, day, stamp string) (api.SnapshotSetFile, error) {
+	f := api.SnapshotSetFile{Database: "globals", Format: "plain",
+		File: fmt.Sprintf("supabase-globals-%s_%s.sql.gz", day, stamp)}
+	p := project.From(ctx)
+	remote := p.SnapshotRemote(day, f.File)
+
+	args := "pg_dumpall -U " + p.DBUser + " --globals-only"
+	if !SnapshotGlobalsPasswords {
+		args += " --no-role-passwords"
+	}
+	// pg_dumpall has no -Z; gzip runs next to it and the script fails if either does.
+	dump := exec.CommandContext(ctx, "docker", "exec", p.DBContainer, "bash", "-o", "pipefail", "-c", args+" | gzip -6")
+	size, err := streamUpload(ctx, dump, remote, nil, nil)
+	if err != nil {
+		return f, err
+	}
+	f.Size = size
+	return f, nil
+}
+
+// ListSnapshotSets returns the manifests stored for one day.
+func ListSnapshotSets(ctx context.Context, day string) ([]api.SnapshotSet, error) {
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return nil, fmt.Errorf("invalid day %q", day)
+	}
+	p := replica.Reader(ctx, project.From(ctx))
+	items, err := listRemote(ctx, p.SnapshotRemote(day))
+	if err != nil {
+		return nil, err
+	}
+	sets := []api.SnapshotSet{}
+	for _, it := range items {
+		if !strings.HasPrefix(it.Name, "snapshot-set_") || !strings.HasSuffix(it.Name, ".json") {
+			continue
+		}
+		out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat",
+			p.SnapshotRemote(day, it.Name)).Output()
+		var set api.SnapshotSet
+		if err == nil && json.Unmarshal(out, &set) == nil {
+			sets = append(sets, set)
+		}
+	}
+	return sets, nil
+}
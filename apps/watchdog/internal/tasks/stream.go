@@ -0,0 +1,160 @@
+package tasks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+)
+
+// UploadChunkSize is the Dropbox upload-session chunk. rclone buffers one
+// chunk in memory and retries it on failure, so a network blip costs one
+// chunk rather than the whole stream (BASE_UPLOAD_CHUNK, e.g. 64M).
+var UploadChunkSize = config.Env("BASE_UPLOAD_CHUNK", "64M")
+
+// pg_basebackup --progress prints "12345/67890 kB (18%), 0/1 tablespace".
+var basebackupProgress = regexp.MustCompile(`(\d+)/(\d+) kB \((\d+)%\)`)
+
+type countingWriter struct {
+	w io.Writer
+	n atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// streamUpload pipes producer's stdout straight into `rclone rcat remote`
+// without touching local disk, and returns the number of bytes uploaded.
+// tee, if set, sees the same bytes (it must never block). onStderr gets every
+// line (or \r-terminated progress update) the producer writes to stderr,
+// along with the number of bytes sent to rclone so far.
+func streamUpload(ctx context.Context, producer *exec.Cmd, remote string, tee io.Writer, onStderr func(line string, sent int64)) (int64, error) {
+	upCtx, cancelUpload := context.WithCancel(ctx)
+	defer cancelUpload()
+	upload := exec.CommandContext(upCtx, "docker", "exec", "-i", "supabase-rclone", "rclone",
+		"--config", "/config/rclone/rclone.conf",
+		"--dropbox-chunk-size", UploadChunkSize,
+		"rcat", remote)
+
+	pr, pw := io.Pipe()
+	sent := &countingWriter{w: pw}
+	producer.Stdout = sent
+	if tee != nil {
+		producer.Stdout = io.MultiWriter(sent, tee)
+	}
+	// Feed rclone ourselves so upload.Wait returns as soon as rclone exits,
+	// even while the copy is blocked waiting for the producer.
+	stdin, err := upload.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	var uploadOut bytes.Buffer
+	upload.Stdout = &uploadOut
+	upload.Stderr = &uploadOut
+
+	stderr, err := producer.StderrPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := upload.Start(); err != nil {
+		return 0, fmt.Errorf("rclone rcat: %w", err)
+	}
+	go func() {
+		io.Copy(stdin, pr)
+		stdin.Close()
+	}()
+	if err := producer.Start(); err != nil {
+		pw.Close()
+		upload.Wait()
+		return 0, err
+	}
+
+	// If rclone exits early (auth, quota, network) nothing reads the pipe any
+	// more and the producer would block on write forever: stop it instead.
+	var uploadDied atomic.Bool
+	upDone := make(chan error, 1)
+	go func() {
+		err := upload.Wait()
+		if err != nil {
+			uploadDied.Store(true)
+			producer.Process.Kill()
+		}
+		pr.CloseWithError(fmt.Errorf("rclone rcat exited: %v", err))
+		upDone <- err
+	}()
+
+	var producerOut bytes.Buffer
+	scanner := bufio.NewScanner(stderr)
+	scanner.Split(scanLinesOrCR)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		producerOut.WriteString(line + "\n")
+		if onStderr != nil {
+			onStderr(line, sent.n.Load())
+		}
+	}
+
+	prodErr := producer.Wait()
+	// A failed producer must also fail the upload, otherwise rclone would
+	// see EOF and happily store the truncated stream.
+	if prodErr != nil {
+		cancelUpload()
+	}
+	pw.CloseWithError(prodErr)
+	upErr := <-upDone
+
+	if upErr != nil && (prodErr == nil || uploadDied.Load()) {
+		return 0, fmt.Errorf("rclone rcat: %w: %s", upErr, lastLines(uploadOut.String(), 5))
+	}
+	if prodErr != nil {
+		return 0, fmt.Errorf("%w: %s", prodErr, lastLines(producerOut.String(), 5))
+	}
+	log.Printf("⬆️ [STREAM] %s uploaded to %s", humanBytes(sent.n.Load()), remote)
+	return sent.n.Load(), nil
+}
+
+// parseBasebackupProgress extracts done/total bytes and percent from a
+// pg_basebackup --progress line.
+func parseBasebackupProgress(line string) (done, total int64, pct int, ok bool) {
+	m := basebackupProgress.FindStringSubmatch(line)
+	if m == nil {
+		return 0, 0, 0, false
+	}
+	d, _ := strconv.ParseInt(m[1], 10, 64)
+	t, _ := strconv.ParseInt(m[2], 10, 64)
+	pct, _ = strconv.Atoi(m[3])
+	return d * 1024, t * 1024, pct, true
+}
+
+// scanLinesOrCR splits on \n and on the bare \r that progress meters use.
+func scanLinesOrCR(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, bytes.TrimSpace(data[:i]), nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), bytes.TrimSpace(data), nil
+	}
+	return 0, nil, nil
+}
+
+func lastLines(s string, n int) string {
+	lines := bytes.Split(bytes.TrimSpace([]byte(s)), []byte("\n"))
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return string(bytes.Join(lines, []byte("; ")))
+}
@@ -0,0 +1,336 @@
+package tasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+)
+
+// pg_verifybackup in PG17 only reads plain-format backups, so tar streams are
+// checked here against the backup_manifest they carry: every listed file must
+// be present with the right size and checksum, and the manifest's own
+// checksum must match.
+
+type backupManifest struct {
+	Files []struct {
+		Path        string `json:"Path"`
+		EncodedPath string `json:"Encoded-Path"`
+		Size        int64  `json:"Size"`
+		Algorithm   string `json:"Checksum-Algorithm"`
+		Checksum    string `json:"Checksum"`
+	} `json:"Files"`
+	ManifestChecksum string `json:"Manifest-Checksum"`
+}
+
+type fileSum struct {
+	size int64
+	sums map[string]string // algorithm -> hex
+}
+
+// backupVerifier consumes a gzipped base backup tar as it streams by.
+type backupVerifier struct {
+	pw   *io.PipeWriter
+	done chan api.BaseVerification
+}
+
+// algorithm is the manifest's checksum algorithm when known in advance; the
+// manifest itself comes last in the tar, so "" computes every supported one.
+func newBackupVerifier(source, algorithm string) *backupVerifier {
+	pr, pw := io.Pipe()
+	v := &backupVerifier{pw: pw, done: make(chan api.BaseVerification, 1)}
+	go func() {
+		res := verifyTarStream(pr, algorithm)
+		io.Copy(io.Discard, pr) // keep the stream flowing whatever happened
+		res.Source = source
+		res.VerifiedAt = time.Now().UTC()
+		v.done <- res
+	}()
+	return v
+}
+
+func (v *backupVerifier) Write(p []byte) (int, error) { return v.pw.Write(p) }
+
+// Wait ends the stream and returns the verification result.
+func (v *backupVerifier) Wait() api.BaseVerification {
+	v.pw.Close()
+	return <-v.done
+}
+
+// manifestHashes are the checksum algorithms a backup manifest can name.
+var manifestHashes = map[string]func() hash.Hash{
+	"CRC32C": func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"SHA224": sha256.New224,
+	"SHA256": sha256.New,
+	"SHA384": sha512.New384,
+	"SHA512": sha512.New,
+}
+
+func verifyTarStream(r io.Reader, algorithm string) api.BaseVerification {
+	fail := func(err error) api.BaseVerification { return api.BaseVerification{Error: err.Error()} }
+
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return fail(err)
+	}
+	tr := tar.NewReader(zr)
+	files := make(map[string]fileSum)
+	var manifest []byte
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("tar: %w", err))
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if hdr.Name == "backup_manifest" {
+			if manifest, err = io.ReadAll(tr); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		hashes := map[string]hash.Hash{}
+		var writers []io.Writer
+		for name, newHash := range manifestHashes {
+			if algorithm == "" || algorithm == name {
+				hashes[name] = newHash()
+				writers = append(writers, hashes[name])
+			}
+		}
+		n, err := io.Copy(io.MultiWriter(writers...), tr)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", hdr.Name, err))
+		}
+		sum := fileSum{size: n, sums: map[string]string{}}
+		for name, h := range hashes {
+			if crc, ok := h.(hash.Hash32); ok {
+				sum.sums[name] = crc32cHex(crc.Sum32())
+			} else {
+				sum.sums[name] = hex.EncodeToString(h.Sum(nil))
+			}
+		}
+		files[hdr.Name] = sum
+	}
+	if manifest == nil {
+		return fail(errors.New("backup_manifest not found in backup"))
+	}
+	return checkManifest(manifest, files)
+}
+
+func checkManifest(raw []byte, files map[string]fileSum) api.BaseVerification {
+	var res api.BaseVerification
+	var m backupManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		res.Error = "manifest: " + err.Error()
+		return res
+	}
+
+	// The manifest checksum covers everything up to the line holding it.
+	body := bytes.TrimSuffix(raw, []byte("\n"))
+	if i := bytes.LastIndexByte(body, '\n'); i < 0 || sha256Hex(body[:i+1]) != m.ManifestChecksum {
+		res.Error = "manifest checksum mismatch"
+		return res
+	}
+
+	var problems []string
+	for _, f := range m.Files {
+		path := f.Path
+		if f.EncodedPath != "" {
+			b, _ := hex.DecodeString(f.EncodedPath)
+			path = string(b)
+		}
+		got, ok := files[path]
+		switch {
+		case !ok:
+			problems = append(problems, path+": missing")
+		case got.size != f.Size:
+			problems = append(problems, fmt.Sprintf("%s: size %d, manifest says %d", path, got.size, f.Size))
+		case f.Algorithm != "" && f.Algorithm != "NONE":
+			sum, known := got.sums[f.Algorithm]
+			switch {
+			case !known:
+				problems = append(problems, fmt.Sprintf("%s: %s checksum not verified", path, f.Algorithm))
+			case !strings.EqualFold(sum, f.Checksum):
+				problems = append(problems, path+": checksum mismatch")
+			}
+		}
+	}
+	res.Files = len(m.Files)
+	res.OK = len(problems) == 0
+	if !res.OK {
+		if len(problems) > 5 {
+			problems = append(problems[:5], fmt.Sprintf("and %d more", len(problems)-5))
+		}
+		res.Error = strings.Join(problems, "; ")
+	}
+	return res
+}
+
+// PostgreSQL stores the CRC32C in native (little-endian) byte order.
+func crc32cHex(v uint32) string {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	return hex.EncodeToString(b[:])
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// manifestAlgorithm reads the checksum algorithm from the side copy of a
+// manifest, or returns "" when there is none.
+func manifestAlgorithm(path string) string {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var m backupManifest
+	if json.Unmarshal(raw, &m) != nil || len(m.Files) == 0 {
+		return ""
+	}
+	return m.Files[0].Algorithm
+}
+
+// VerifySampledBaseBackups downloads BASE_VERIFY_SAMPLE randomly chosen base
+// backups from the last 30 days and verifies them against their manifests.
+func VerifySampledBaseBackups(ctx context.Context, tg *telegram.Service) error {
+	n, err := strconv.Atoi(config.Env("BASE_VERIFY_SAMPLE", "1"))
+	if err != nil || n < 1 {
+		n = 1
+	}
+	days, err := recentBaseDays(ctx, 30)
+	if err != nil {
+		return err
+	}
+	rand.Shuffle(len(days), func(i, j int) { days[i], days[j] = days[j], days[i] })
+	if len(days) > n {
+		days = days[:n]
+	}
+
+	var failed []string
+	for _, d := range days {
+		if res, err := VerifyRemoteBaseBackup(ctx, d, tg); err != nil || !res.OK {
+			failed = append(failed, d)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("base backup verification failed for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// VerifyRemoteBaseBackup streams one day's base.tar.gz back from remote
+// storage through the verifier and records the result in its metadata.
+func VerifyRemoteBaseBackup(ctx context.Context, date string, tg *telegram.Service) (res api.BaseVerification, err error) {
+	ctx, finish, err := begin(ctx, tg, "verify_base")
+	if err != nil {
+		return res, err
+	}
+	defer func() { finish(err == nil) }()
+
+	remoteRoot := project.From(ctx).WALRemote(date)
+	log.Printf("🔎 [VERIFY] Downloading base backup %s for verification...", date)
+
+	v := newBackupVerifier("download", manifestAlgorithm(manifestPath(ctx, date)))
+	cmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remoteRoot+"/base.tar.gz")
+	cmd.Stdout = v
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	res = v.Wait()
+	if runErr != nil {
+		res.OK = false
+		res.Error = fmt.Sprintf("download: %v: %s", runErr, lastLines(stderr.String(), 3))
+	}
+
+	recordVerification(ctx, date, res)
+	if !res.OK {
+		log.Printf("❌ [VERIFY] Base backup %s failed verification: %s", date, res.Error)
+		tg.Send(fmt.Sprintf("🚨 *Base backup %s failed verification*\n%s\nPITR for that day may be impossible.", date, truncateText(res.Error, 500)))
+		return res, nil
+	}
+	log.Printf("✅ [VERIFY] Base backup %s verified (%d files)", date, res.Files)
+	tg.Send(fmt.Sprintf("🔎 Sampled base backup %s re-downloaded and verified (%d files).", date, res.Files))
+	return res, nil
+}
+
+// recordVerification stores the result in base_backup.json and, for days
+// that were already archived, in metadata.json as well.
+func recordVerification(ctx context.Context, date string, res api.BaseVerification) {
+	remoteRoot := project.From(ctx).WALRemote(date)
+	ctx = context.WithoutCancel(ctx)
+
+	if info, err := fetchBaseInfo(ctx, remoteRoot); err == nil {
+		info.Verification = &res
+		if _, err := os.Stat(baseInfoPath(ctx, date)); err == nil {
+			b, _ := json.MarshalIndent(info, "", "  ")
+			os.WriteFile(baseInfoPath(ctx, date), b, 0644)
+		}
+		if err := uploadBaseInfo(ctx, remoteRoot, *info); err != nil {
+			log.Printf("⚠️ [VERIFY] Could not update base_backup.json for %s: %v", date, err)
+		}
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remoteRoot+"/metadata.json").Output()
+	if err != nil {
+		return
+	}
+	var meta api.PitrMetadata
+	if json.Unmarshal(out, &meta) != nil {
+		return
+	}
+	meta.BaseVerification = &res
+	saveAndUploadMetadata(ctx, date, localMetaPath(ctx, date), remoteRoot, meta)
+}
+
+// recentBaseDays lists days of the last n that have a base backup.
+func recentBaseDays(ctx context.Context, n int) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone",
+		"lsf", "-R", "--files-only", "--include", "/*/base.tar.gz", project.From(ctx).WALRemote()).Output()
+	if err != nil {
+		return nil, fmt.Errorf("list base backups: %w", err)
+	}
+	cutoff := time.Now().AddDate(0, 0, -n).Format("2006-01-02")
+	var days []string
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		day, _, ok := strings.Cut(line, "/")
+		if ok && day >= cutoff {
+			days = append(days, day)
+		}
+	}
+	return days, nil
+}
+
+func truncateText(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
@@ -0,0 +1,328 @@
+package tasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/api"
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/klauspost/compress/zstd"
+)
+
+// WALCompression picks the day archive format: gzip (WAL_archive.tar.gz,
+// the historical default) or zstd (WAL_archive.tar.zst). WAL_ARCHIVE_COMPRESSION.
+var WALCompression = config.Env("WAL_ARCHIVE_COMPRESSION", "gzip")
+
+func walArchiveName(compression string) string {
+	if compression == "zstd" {
+		return "WAL_archive.tar.zst"
+	}
+	return "WAL_archive.tar.gz"
+}
+
+func isWALArchive(name string) bool {
+	return name == "WAL_archive.tar.gz" || name == "WAL_archive.tar.zst"
+}
+
+// listRemote returns the files directly under a remote folder.
+func listRemote(ctx context.Context, remote string) ([]RcloneItem, error) {
+	out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "lsjson", "--files-only", remote).Output()
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", remote, err)
+	}
+	var items []RcloneItem
+	if err := json.Unmarshal(out, &items); err != nil {
+		return nil, fmt.Errorf("list %s: %w", remote, err)
+	}
+	return items, nil
+}
+
+// buildWALArchive streams every segment of remoteRoot/WAL through a tar
+// writer and compressor straight back to remote storage, one segment in
+// memory at a time and nothing on local disk. The upload is checked against
+// what was written (size, content hash, segment count) before it is published.
+func buildWALArchive(ctx context.Context, remoteRoot string, segs []RcloneItem) (api.WALArchive, error) {
+	info := api.WALArchive{Name: walArchiveName(WALCompression), Compression: WALCompression}
+	if info.Compression != "zstd" {
+		info.Compression = "gzip"
+	}
+	final := remoteRoot + "/" + info.Name
+	partial := final + ".partial"
+
+	sha := sha256.New()
+	dbx := newDropboxHash()
+	counter := &countingWriter{w: io.Discard}
+
+	pr, pw := io.Pipe()
+	written := make(chan int, 1)
+	writeErr := make(chan error, 1)
+	go func() {
+		n, err := writeWALTar(ctx, io.MultiWriter(pw, sha, dbx, counter), remoteRoot, segs, info.Compression)
+		pw.CloseWithError(err)
+		written <- n
+		writeErr <- err
+	}()
+
+	upErr := rcatFrom(ctx, pr, partial)
+	pr.CloseWithError(upErr) // unblock the writer if the upload died first
+	info.Segments = <-written
+	if err := <-writeErr; err != nil {
+		upErr = err
+	}
+	if upErr != nil {
+		exec.Command("docker", "exec", "supabase-rclone", "rclone", "deletefile", partial).Run()
+		return info, upErr
+	}
+	info.Size = counter.n.Load()
+	info.SHA256 = hex.EncodeToString(sha.Sum(nil))
+
+	if err := verifyRemoteUpload(ctx, partial, info.Size, dbx.Sum()); err != nil {
+		exec.Command("docker", "exec", "supabase-rclone", "rclone", "deletefile", partial).Run()
+		return info, err
+	}
+
+	if out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "moveto", partial, final).CombinedOutput(); err != nil {
+		return info, fmt.Errorf("rclone moveto: %w: %s", err, out)
+	}
+	info.Verified = true
+	return info, nil
+}
+
+// writeWALTar writes the tar of all segments and returns how many made it in.
+func writeWALTar(ctx context.Context, w io.Writer, remoteRoot string, segs []RcloneItem, compression string) (int, error) {
+	var comp io.WriteCloser
+	if compression == "zstd" {
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return 0, err
+		}
+		comp = zw
+	} else {
+		comp = gzip.NewWriter(w)
+	}
+	tw := tar.NewWriter(comp)
+
+	n := 0
+	for _, s := range segs {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		data, err := fetchSegment(ctx, remoteRoot+"/WAL/"+s.Name, s.Size)
+		if err != nil {
+			return n, err
+		}
+		hdr := &tar.Header{Name: "WAL/" + s.Name, Mode: 0600, Size: int64(len(data)), ModTime: s.ModTime, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return n, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := tw.Close(); err != nil {
+		return n, err
+	}
+	return n, comp.Close()
+}
+
+// fetchSegment downloads one segment into memory, retrying short or failed reads.
+func fetchSegment(ctx context.Context, remote string, size int64) ([]byte, error) {
+	var lastErr error
+	for attempt := 1; attempt <= 3; attempt++ {
+		out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remote).Output()
+		if err == nil && int64(len(out)) == size {
+			return out, nil
+		}
+		lastErr = err
+		if err == nil {
+			lastErr = fmt.Errorf("got %d bytes, expected %d", len(out), size)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+	return nil, fmt.Errorf("fetch %s: %w", path.Base(remote), lastErr)
+}
+
+// verifyRemoteUpload compares the stored object's size and Dropbox content
+// hash with what was streamed. Remotes without that hash are re-downloaded.
+func verifyRemoteUpload(ctx context.Context, remote string, size int64, dropboxHash string) error {
+	items, err := listRemote(ctx, remote)
+	if err != nil || len(items) != 1 {
+		return fmt.Errorf("verify: cannot stat uploaded archive: %v", err)
+	}
+	if items[0].Size != size {
+		return fmt.Errorf("verify: uploaded size %d, streamed %d", items[0].Size, size)
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "hashsum", "dropbox", remote).Output()
+	if sum, _, _ := strings.Cut(strings.TrimSpace(string(out)), " "); err == nil && sum != "" {
+		if sum != dropboxHash {
+			return fmt.Errorf("verify: content hash mismatch (remote %s, streamed %s)", sum, dropboxHash)
+		}
+		return nil
+	}
+
+	h := newDropboxHash()
+	cmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remote)
+	cmd.Stdout = h
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("verify: re-download: %w", err)
+	}
+	if got := h.Sum(); got != dropboxHash {
+		return fmt.Errorf("verify: re-downloaded content differs (%s vs %s)", got, dropboxHash)
+	}
+	return nil
+}
+
+// readWALArchive streams an existing day archive and returns its segments
+// (name -> mod time) without extracting anything to disk.
+func readWALArchive(ctx context.Context, remote string) (map[string]time.Time, api.WALArchive, error) {
+	info := api.WALArchive{Name: path.Base(remote), Compression: "gzip"}
+	if strings.HasSuffix(remote, ".zst") {
+		info.Compression = "zstd"
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", "exec", "supabase-rclone", "rclone", "cat", remote)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, info, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, info, err
+	}
+	defer cmd.Wait()
+
+	sha := sha256.New()
+	counter := &countingWriter{w: sha}
+	r := io.TeeReader(stdout, counter)
+
+	var dr io.Reader
+	if info.Compression == "zstd" {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, info, err
+		}
+		defer zr.Close()
+		dr = zr
+	} else {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, info, err
+		}
+		dr = gr
+	}
+
+	walMap := make(map[string]time.Time)
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, info, fmt.Errorf("read %s: %w", info.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			info.Segments++
+			if name := path.Base(hdr.Name); len(name) >= 24 {
+				walMap[name] = hdr.ModTime
+			}
+		}
+	}
+	io.Copy(io.Discard, r)
+	info.Size = counter.n.Load()
+	info.SHA256 = hex.EncodeToString(sha.Sum(nil))
+	info.Verified = true
+	return walMap, info, nil
+}
+
+// rcatFrom uploads r to remote through `rclone rcat`.
+func rcatFrom(ctx context.Context, r io.Reader, remote string) error {
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-i", "supabase-rclone", "rclone",
+		"--dropbox-chunk-size", UploadChunkSize, "rcat", remote)
+	cmd.Stdin = r
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("rclone rcat: %w: %s", err, lastLines(out.String(), 5))
+	}
+	return nil
+}
+
+// dropboxHash is Dropbox's content hash: SHA-256 over the concatenated
+// SHA-256 digests of 4 MiB blocks. rclone exposes it as the "dropbox" hash.
+type dropboxHash struct {
+	overall hash.Hash
+	block   hash.Hash
+	n       int
+}
+
+const dropboxBlock = 4 * 1024 * 1024
+
+func newDropboxHash() *dropboxHash {
+	return &dropboxHash{overall: sha256.New(), block: sha256.New()}
+}
+
+func (d *dropboxHash) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		take := min(len(p), dropboxBlock-d.n)
+		d.block.Write(p[:take])
+		d.n += take
+		p = p[take:]
+		if d.n == dropboxBlock {
+			d.overall.Write(d.block.Sum(nil))
+			d.block.Reset()
+			d.n = 0
+		}
+	}
+	return total, nil
+}
+
+func (d *dropboxHash) Sum() string {
+	if d.n > 0 {
+		d.overall.Write(d.block.Sum(nil))
+		d.block.Reset()
+		d.n = 0
+	}
+	return hex.EncodeToString(d.overall.Sum(nil))
+}
+
+// walMapFrom keeps segment-looking names from a remote listing.
+func walMapFrom(items []RcloneItem, date string) map[string]time.Time {
+	fallbackTime, _ := time.Parse("2006-01-02", date)
+	walMap := make(map[string]time.Time)
+	for _, f := range items {
+		if len(f.Name) < 24 {
+			continue
+		}
+		if f.ModTime.Year() > 2000 {
+			walMap[f.Name] = f.ModTime
+		} else {
+			// If we can't get a real time, use the fallback
+			walMap[f.Name] = fallbackTime
+		}
+	}
+	return walMap
+}
+
+func logArchive(date string, a api.WALArchive) {
+	log.Printf("📦 [ARCHIVE] %s: %s, %d segments, %s, sha256 %s", date, a.Name, a.Segments, humanBytes(a.Size), a.SHA256[:12])
+}
@@ -8,13 +8,39 @@ import (
 	"net/url"
 	"os"
 	"strings" // Added
+	"sync"
 	"time"
 )
 
+// sendInterval spaces messages of one bot to respect Telegram rate limits.
+const sendInterval = 200 * time.Millisecond
+
 type Service struct {
 	BotToken string
 	ChatID   string
+	Prefix   string // prepended to every message, e.g. "[dev] "
 	Queue    chan string
+
+	client  *http.Client
+	limiter *limiter // shared by every service of the same bot
+}
+
+// limiter hands out send slots at most every sendInterval.
+type limiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (l *limiter) wait() {
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(sendInterval)
+	l.mu.Unlock()
+	time.Sleep(time.Until(slot))
 }
 
 func New() *Service {
@@ -28,10 +54,22 @@ func New() *Service {
 		BotToken: token,
 		ChatID:   chatID,
 		Queue:    make(chan string, 100),
+		client:   &http.Client{Timeout: 15 * time.Second},
+		limiter:  &limiter{},
+	}
+}
+
+// For returns a service for one project: same bot, its own chat (empty
+// keeps this one) and a prefix naming the project. Start its worker too.
+func (s *Service) For(chatID, prefix string) *Service {
+	if chatID == "" {
+		chatID = s.ChatID
 	}
+	return &Service{BotToken: s.BotToken, ChatID: chatID, Prefix: prefix, Queue: make(chan string, 100), client: s.client, limiter: s.limiter}
 }
 
 func (s *Service) Send(msg string) {
+	msg = s.Prefix + msg
 	log.Printf("📢 [WATCHDOG] Outgoing message: %s", msg)
 	select {
 	case s.Queue <- msg:
@@ -45,35 +83,53 @@ func (s *Service) StartWorker() {
 	go func() {
 		for msg := range s.Queue {
 			s.postMessage(msg)
-			time.Sleep(200 * time.Millisecond) // Respect Telegram rate limits
 		}
 	}()
 }
 
-func (s *Service) postMessage(text string) {
+// Deliver posts msg without queueing, in the same send slots as the worker,
+// and reports whether Telegram accepted it.
+// Use it when the caller must not lose the message (e.g. before acking a queue).
+func (s *Service) Deliver(msg string) error {
+	msg = s.Prefix + msg
+	log.Printf("📢 [WATCHDOG] Delivering message: %s", msg)
+	return s.postMessage(msg)
+}
+
+// Drain waits until queued messages were handed to Telegram, or timeout.
+func (s *Service) Drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for len(s.Queue) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (s *Service) postMessage(text string) error {
 	if s.BotToken == "" {
 		log.Println("❌ [TELEGRAM] Cannot send: TELEGRAM_BOT_TOKEN is empty in environment")
-		return
+		return fmt.Errorf("TELEGRAM_BOT_TOKEN is empty")
 	}
 
 	apiURL := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", s.BotToken)
-	
-	resp, err := http.PostForm(apiURL, url.Values{
+
+	s.limiter.wait()
+	resp, err := s.client.PostForm(apiURL, url.Values{
 		"chat_id": {s.ChatID},
 		"text":    {text},
 	})
 
 	if err != nil {
 		log.Printf("❌ [TELEGRAM] Network Error: %v", err)
-		return
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		log.Printf("❌ [TELEGRAM] API Error %d: %s", resp.StatusCode, string(body))
-		return
+		return fmt.Errorf("telegram api error %d", resp.StatusCode)
 	}
 
 	log.Printf("✅ [TELEGRAM] Message delivered")
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+	"github.com/redis/go-redis/v9"
+)
+
+// dispatcher delivers a rendered message to a handler and only returns nil
+// once the target confirmed it.
+type dispatcher struct {
+	tg   *telegram.Service
+	rdb  *redis.Client
+	http *http.Client
+}
+
+func (d *dispatcher) deliver(ctx context.Context, h *HandlerRoute, data map[string]interface{}) error {
+	body, err := render(h.tmpl, data)
+	if err != nil {
+		return fmt.Errorf("template: %w", err)
+	}
+
+	switch h.Type {
+	case "telegram":
+		return d.tg.Deliver(body)
+	case "webhook":
+		return d.webhook(ctx, h, body)
+	case "email":
+		subject := fmt.Sprintf("[watchdog] %v", data["_queue"])
+		if h.subject != nil {
+			if subject, err = render(h.subject, data); err != nil {
+				return fmt.Errorf("subject template: %w", err)
+			}
+		}
+		return sendMail(h.To, subject, body)
+	case "redis":
+		return d.rdb.Publish(ctx, h.Channel, body).Err()
+	}
+	return fmt.Errorf("unknown handler type %q", h.Type)
+}
+
+func (d *dispatcher) webhook(ctx context.Context, h *HandlerRoute, body string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %d", h.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// sendMail uses the SMTP_* settings shared with GoTrue and the mailserver.
+func sendMail(to []string, subject, body string) error {
+	host := config.Env("SMTP_HOST", "")
+	if host == "" || len(to) == 0 {
+		return fmt.Errorf("email handler needs SMTP_HOST and recipients")
+	}
+	port := config.Env("SMTP_PORT", "587")
+	user := config.Env("SMTP_USER", "")
+	from := config.Env("SMTP_FROM", user)
+
+	var auth smtp.Auth
+	if user != "" {
+		auth = smtp.PlainAuth("", user, config.Env("SMTP_PASS", ""), host)
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+		from, strings.Join(to, ", "), subject, time.Now().Format(time.RFC1123Z), body)
+	return smtp.SendMail(net.JoinHostPort(host, port), auth, from, to, []byte(msg))
+}
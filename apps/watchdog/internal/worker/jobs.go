@@ -0,0 +1,230 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/project"
+	"github.com/GoldenCarrotMLP/watchdog/internal/tasks"
+	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const jobsQueue = "watchdog_jobs"
+
+// JobRequest is the message an app sends to the watchdog_jobs queue, e.g.
+// select pgmq.send('watchdog_jobs', '{"action":"snapshot","requested_by":"migration 42"}');
+// Project names the project the job runs for (default main).
+type JobRequest struct {
+	Action      string          `json:"action"`
+	Project     string          `json:"project,omitempty"`
+	Params      json.RawMessage `json:"params,omitempty"`
+	RequestedBy string          `json:"requested_by,omitempty"`
+}
+
+type jobParams struct {
+	Date   string `json:"date,omitempty"`    // archive_day, combine_base, verify_base
+	DryRun bool   `json:"dry_run,omitempty"` // disk_cleanup
+
+	Restore   tasks.SnapshotRestore  `json:"restore,omitempty"`   // restore_snapshot
+	Selective tasks.SelectiveRestore `json:"selective,omitempty"` // restore_objects
+}
+
+// jobActions is the allowlist of what SQL may ask for. Each entry runs the same
+// task code as the cron schedule.
+var jobActions = map[string]func(ctx context.Context, tg *telegram.Service, p jobParams) error{
+	"snapshot": func(ctx context.Context, tg *telegram.Service, _ jobParams) error {
+		return tasks.RunFullBackup(ctx, tg)
+	},
+	"base_backup": func(ctx context.Context, tg *telegram.Service, _ jobParams) error {
+		return tasks.RunDailyBaseBackup(ctx, tg)
+	},
+	"archive_day": func(ctx context.Context, tg *telegram.Service, p jobParams) error {
+		if p.Date == "" {
+			p.Date = time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+		}
+		// ArchiveRemoteDay also rejects invalid dates and today or later.
+		return tasks.ArchiveRemoteDay(ctx, p.Date, tg)
+	},
+	"combine_base": func(ctx context.Context, tg *telegram.Service, p jobParams) error {
+		if _, err := time.Parse("2006-01-02", p.Date); err != nil {
+			return fmt.Errorf("invalid date %q", p.Date)
+		}
+		_, err := tasks.CombineBaseChain(ctx, p.Date, tg)
+		return err
+	},
+	"verify_base": func(ctx context.Context, tg *telegram.Service, p jobParams) error {
+		if p.Date == "" {
+			return tasks.VerifySampledBaseBackups(ctx, tg)
+		}
+		res, err := tasks.VerifyRemoteBaseBackup(ctx, p.Date, tg)
+		if err == nil && !res.OK {
+			err = fmt.Errorf("verification failed: %s", res.Error)
+		}
+		return err
+	},
+	"backfill": func(ctx context.Context, tg *telegram.Service, _ jobParams) error {
+		return tasks.RunStartupBackfill(ctx, tg)
+	},
+	"recovery_report": func(ctx context.Context, tg *telegram.Service, _ jobParams) error {
+		return tasks.RunRecoveryReport(ctx, tg)
+	},
+	"restore_snapshot": func(ctx context.Context, tg *telegram.Service, p jobParams) error {
+		_, err := tasks.RestoreSnapshot(ctx, tg, p.Restore)
+		return err
+	},
+	"restore_objects": func(ctx context.Context, tg *telegram.Service, p jobParams) error {
+		_, err := tasks.RestoreSnapshotObjects(ctx, tg, p.Selective)
+		return err
+	},
+	"restore_drill": func(ctx context.Context, tg *telegram.Service, _ jobParams) error {
+		return tasks.RunRestoreDrill(ctx, tg)
+	},
+	"replica_parity": func(ctx context.Context, tg *telegram.Service, _ jobParams) error {
+		return tasks.RunReplicaParity(ctx, tg)
+	},
+	"disk_cleanup": func(ctx context.Context, tg *telegram.Service, p jobParams) error {
+		return tasks.RunDiskCleanup(ctx, tg, p.DryRun)
+	},
+}
+
+// pitrActions need WAL archiving; projects without a wal_dir reject them.
+var pitrActions = map[string]bool{
+	"base_backup": true, "archive_day": true, "combine_base": true, "verify_base": true, "backfill": true,
+}
+
+const jobsSchema = `
+CREATE SCHEMA IF NOT EXISTS watchdog;
+CREATE TABLE IF NOT EXISTS watchdog.job_status (
+	msg_id       bigint PRIMARY KEY,
+	action       text NOT NULL,
+	params       jsonb NOT NULL DEFAULT '{}',
+	requested_by text,
+	status       text NOT NULL CHECK (status IN ('running', 'succeeded', 'failed', 'rejected')),
+	error        text,
+	progress     jsonb,
+	created_at   timestamptz NOT NULL DEFAULT now(),
+	started_at   timestamptz,
+	finished_at  timestamptz
+);
+ALTER TABLE watchdog.job_status ADD COLUMN IF NOT EXISTS progress jsonb;
+ALTER TABLE watchdog.job_status ADD COLUMN IF NOT EXISTS project text;
+GRANT USAGE ON SCHEMA watchdog TO service_role;
+GRANT SELECT ON watchdog.job_status TO service_role;
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_publication WHERE pubname = 'supabase_realtime')
+	   AND NOT EXISTS (SELECT 1 FROM pg_publication_tables
+	                   WHERE pubname = 'supabase_realtime' AND schemaname = 'watchdog' AND tablename = 'job_status') THEN
+		ALTER PUBLICATION supabase_realtime ADD TABLE watchdog.job_status;
+	END IF;
+END $$;
+SELECT pgmq.create('` + jobsQueue + `');
+`
+
+// runJobConsumer executes ops actions requested from SQL, one at a time.
+// A job is archived as soon as it is picked up so a crash never replays an
+// action twice; its outcome is tracked in watchdog.job_status instead.
+func runJobConsumer(ctx context.Context, tg *telegram.Service, pool *pgxpool.Pool) {
+	if _, err := pool.Exec(ctx, jobsSchema); err != nil {
+		log.Printf("❌ [JOBS] Could not prepare watchdog.job_status: %v", err)
+		tg.Send(fmt.Sprintf("⚠️ [JOBS] Job queue disabled: %v", err))
+		return
+	}
+	log.Printf("📥 [JOBS] Consumer started for %s", jobsQueue)
+
+	for ctx.Err() == nil {
+		msgs, err := readPGMQ(ctx, pool, jobsQueue)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("⚠️ [JOBS] Read error: %v", err)
+				sleepCtx(ctx, 5*time.Second)
+			}
+			continue
+		}
+		for _, m := range msgs {
+			runJob(ctx, tg, pool, m)
+		}
+	}
+}
+
+func runJob(ctx context.Context, tg *telegram.Service, pool *pgxpool.Pool, m pgmqMessage) {
+	if _, err := pool.Exec(ctx, `SELECT pgmq.archive($1, $2::bigint)`, jobsQueue, m.ID); err != nil {
+		log.Printf("⚠️ [JOBS] Archive of %d failed, skipping: %v", m.ID, err)
+		return
+	}
+
+	var req JobRequest
+	var p jobParams
+	err := json.Unmarshal(m.Payload, &req)
+	if err == nil && len(req.Params) > 0 {
+		err = json.Unmarshal(req.Params, &p)
+	}
+	if len(req.Params) == 0 {
+		req.Params = json.RawMessage(`{}`)
+	}
+
+	action, ok := jobActions[req.Action]
+	proj, perr := project.Get(req.Project)
+	if err != nil || !ok || perr != nil {
+		reason := fmt.Sprintf("unknown action %q", req.Action)
+		if err != nil {
+			reason = "invalid payload: " + err.Error()
+		} else if perr != nil {
+			reason = perr.Error()
+		}
+		log.Printf("🚫 [JOBS] Rejected job %d: %s", m.ID, reason)
+		setJobStatus(ctx, pool, m.ID, req, "rejected", reason)
+		return
+	}
+	if pitrActions[req.Action] && !proj.PITR() {
+		reason := fmt.Sprintf("project %s has no PITR, %s is not available", proj.Name, req.Action)
+		log.Printf("🚫 [JOBS] Rejected job %d: %s", m.ID, reason)
+		setJobStatus(ctx, pool, m.ID, req, "rejected", reason)
+		return
+	}
+
+	req.Project = proj.Name
+	log.Printf("⚙️ [JOBS] Running %s for %s (job %d, requested by %s)", req.Action, proj.Name, m.ID, req.RequestedBy)
+	setJobStatus(ctx, pool, m.ID, req, "running", "")
+
+	// Long tasks report progress into job_status, which realtime pushes to clients.
+	jobCtx := tasks.WithProgress(project.With(ctx, proj), func(pr tasks.Progress) { setJobProgress(ctx, pool, m.ID, pr) })
+	if err := action(jobCtx, proj.Notifier(tg), p); err != nil {
+		log.Printf("❌ [JOBS] %s (job %d) failed: %v", req.Action, m.ID, err)
+		setJobStatus(ctx, pool, m.ID, req, "failed", err.Error())
+		return
+	}
+	setJobStatus(ctx, pool, m.ID, req, "succeeded", "")
+	log.Printf("✅ [JOBS] %s (job %d) succeeded", req.Action, m.ID)
+}
+
+func setJobStatus(ctx context.Context, pool *pgxpool.Pool, msgID int64, req JobRequest, status, errMsg string) {
+	// Record the outcome even when the job finished during shutdown.
+	_, err := pool.Exec(context.WithoutCancel(ctx), `
+		INSERT INTO watchdog.job_status (msg_id, action, params, requested_by, status, error, started_at, finished_at, project)
+		VALUES ($1, $2, $3::jsonb, nullif($4, ''), $5, nullif($6, ''),
+		        CASE WHEN $5 = 'running' THEN now() END,
+		        CASE WHEN $5 IN ('succeeded', 'failed', 'rejected') THEN now() END,
+		        nullif($7, ''))
+		ON CONFLICT (msg_id) DO UPDATE SET
+			status      = EXCLUDED.status,
+			error       = EXCLUDED.error,
+			started_at  = coalesce(watchdog.job_status.started_at, EXCLUDED.started_at),
+			finished_at = EXCLUDED.finished_at`,
+		msgID, req.Action, string(req.Params), req.RequestedBy, status, errMsg, req.Project)
+	if err != nil {
+		log.Printf("⚠️ [JOBS] Could not record status %s for job %d: %v", status, msgID, err)
+	}
+}
+
+func setJobProgress(ctx context.Context, pool *pgxpool.Pool, msgID int64, p tasks.Progress) {
+	b, _ := json.Marshal(p)
+	if _, err := pool.Exec(context.WithoutCancel(ctx),
+		`UPDATE watchdog.job_status SET progress = $2::jsonb WHERE msg_id = $1`, msgID, string(b)); err != nil {
+		log.Printf("⚠️ [JOBS] Could not record progress for job %d: %v", msgID, err)
+	}
+}
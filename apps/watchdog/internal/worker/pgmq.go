@@ -0,0 +1,149 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	pgmqVisibility = 30 // seconds a read message stays invisible to other readers
+	pgmqBatch      = 50
+	pgmqPollWait   = 5 // seconds read_with_poll blocks waiting for messages
+
+	// deliveredTTL bounds how long per-handler delivery records stay in
+	// Redis; far beyond max_reads retries of any queue.
+	deliveredTTL = 7 * 24 * time.Hour
+)
+
+// pgmqMessage is one row of pgmq.read_with_poll.
+type pgmqMessage struct {
+	ID      int64
+	ReadCt  int
+	Payload json.RawMessage
+}
+
+// runPGMQConsumer long-polls one queue. A message is archived only after every
+// handler confirmed delivery; otherwise its visibility timeout expires and it is
+// retried, until read_ct exceeds the queue's max_reads and it is dead-lettered.
+// Handlers that already confirmed a message are recorded in Redis and skipped
+// on retries, so one failing target does not repeat the others' messages.
+func runPGMQConsumer(ctx context.Context, d *dispatcher, pool *pgxpool.Pool, route QueueRoute, dlq string) {
+	queue := route.Name
+	log.Printf("📥 [PGMQ] Consumer started for %s (%d handlers)", queue, len(route.Handlers))
+
+	for ctx.Err() == nil {
+		msgs, err := readPGMQ(ctx, pool, queue)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("⚠️ [PGMQ] Read error on %s: %v", queue, err)
+				sleepCtx(ctx, 5*time.Second)
+			}
+			continue
+		}
+
+		for _, m := range msgs {
+			log.Printf("📩 [PGMQ] Processing MsgID: %d from Queue: %s (read %d)", m.ID, queue, m.ReadCt)
+
+			if m.ReadCt > route.MaxReads {
+				deadLetter(ctx, d, pool, queue, dlq, m)
+				continue
+			}
+
+			// The batch is delivered one by one: renew this message's
+			// visibility so a slow batch does not let it reappear mid-delivery.
+			if _, err := pool.Exec(ctx, `SELECT pgmq.set_vt($1, $2::bigint, $3)`, queue, m.ID, pgmqVisibility); err != nil {
+				log.Printf("⚠️ [PGMQ] Extending visibility of %d failed, will retry after vt: %v", m.ID, err)
+				continue
+			}
+
+			data := payloadData(queue, m)
+			key := fmt.Sprintf("watchdog:pgmq:%s:%d", queue, m.ID)
+			delivered := true
+			for i := range route.Handlers {
+				h := &route.Handlers[i]
+				multi := len(route.Handlers) > 1
+				field := fmt.Sprintf("%d:%s", i, h.Type)
+				// A Redis error means "not recorded": deliver again rather than lose it.
+				if multi {
+					if done, _ := d.rdb.HExists(ctx, key, field).Result(); done {
+						continue
+					}
+				}
+				if err := d.deliver(ctx, h, data); err != nil {
+					log.Printf("⚠️ [PGMQ] %s handler failed for %d, will retry after vt: %v", h.Type, m.ID, err)
+					delivered = false
+					break
+				}
+				if multi {
+					d.rdb.HSet(ctx, key, field, time.Now().Unix())
+					d.rdb.Expire(ctx, key, deliveredTTL)
+				}
+			}
+			if !delivered {
+				continue
+			}
+			// Archive even if shutdown started: the message was already delivered.
+			if _, err := pool.Exec(context.WithoutCancel(ctx), `SELECT pgmq.archive($1, $2::bigint)`, queue, m.ID); err != nil {
+				log.Printf("⚠️ [PGMQ] Archive of %d failed: %v", m.ID, err)
+				continue
+			}
+			d.rdb.Del(context.WithoutCancel(ctx), key)
+		}
+	}
+	log.Printf("🛑 [PGMQ] Consumer for %s stopped", queue)
+}
+
+// sleepCtx waits for d or until ctx is cancelled.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// deadLetter moves a message that keeps failing to the dead-letter queue,
+// wrapped with where it came from, and archives the original.
+func deadLetter(ctx context.Context, d *dispatcher, pool *pgxpool.Pool, queue, dlq string, m pgmqMessage) {
+	wrapped, _ := json.Marshal(map[string]interface{}{
+		"queue":   queue,
+		"msg_id":  m.ID,
+		"read_ct": m.ReadCt,
+		"payload": m.Payload,
+	})
+	if _, err := pool.Exec(ctx, `SELECT pgmq.send($1, $2::jsonb)`, dlq, string(wrapped)); err != nil {
+		log.Printf("❌ [PGMQ] Dead-lettering %d from %s failed: %v", m.ID, queue, err)
+		return
+	}
+	if _, err := pool.Exec(ctx, `SELECT pgmq.archive($1, $2::bigint)`, queue, m.ID); err != nil {
+		log.Printf("⚠️ [PGMQ] Archive of %d failed: %v", m.ID, err)
+	}
+	log.Printf("💀 [PGMQ] MsgID %d from %s moved to %s after %d reads", m.ID, queue, dlq, m.ReadCt)
+	d.tg.Send(fmt.Sprintf("💀 [PGMQ] Message %d from %s moved to %s after %d failed reads", m.ID, queue, dlq, m.ReadCt))
+}
+
+func readPGMQ(ctx context.Context, pool *pgxpool.Pool, queue string) ([]pgmqMessage, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT msg_id, read_ct, message FROM pgmq.read_with_poll($1, $2, $3, $4)`,
+		queue, pgmqVisibility, pgmqBatch, pgmqPollWait)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []pgmqMessage
+	for rows.Next() {
+		var m pgmqMessage
+		if err := rows.Scan(&m.ID, &m.ReadCt, &m.Payload); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
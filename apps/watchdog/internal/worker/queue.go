@@ -2,20 +2,20 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
-	"os/exec"
-	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+	"github.com/GoldenCarrotMLP/watchdog/internal/db"
 	"github.com/GoldenCarrotMLP/watchdog/internal/tasks"
 	"github.com/GoldenCarrotMLP/watchdog/internal/telegram"
 )
 
-func StartWorkers(tg *telegram.Service) {
-	ctx := context.Background()
+func StartWorkers(ctx context.Context, tg *telegram.Service) {
 
 	// 1. Redis Listener (Keep this, it's working)
 	redisHost := os.Getenv("REDIS_HOST")
@@ -27,76 +27,61 @@ func StartWorkers(tg *telegram.Service) {
 	go func() {
 		pubsub := rdb.Subscribe(ctx, "disk.cleanup.request", "notify.telegram")
 		log.Printf("📥 [WORKER] Redis listener active on %s", redisHost)
+		context.AfterFunc(ctx, func() { pubsub.Close() })
 
 		for msg := range pubsub.Channel() {
 			switch msg.Channel {
 			case "disk.cleanup.request":
-				log.Println("🧹 [REDIS] Cleanup request received")
-				tasks.RunDiskCleanup(tg)
+				// Payload {"dry_run": true} only reports what would be removed; an
+				// empty payload cleans up, anything that is not valid JSON is rejected.
+				var req struct {
+					DryRun bool `json:"dry_run"`
+				}
+				if msg.Payload != "" {
+					if err := json.Unmarshal([]byte(msg.Payload), &req); err != nil {
+						log.Printf("❌ [REDIS] Cleanup request rejected, invalid payload %.200q: %v", msg.Payload, err)
+						tg.Send(fmt.Sprintf("❌ Disk cleanup request rejected: invalid payload (%v)", err))
+						continue
+					}
+				}
+				log.Printf("🧹 [REDIS] Cleanup request received (dry run: %v)", req.DryRun)
+				go tasks.RunDiskCleanup(ctx, tg, req.DryRun)
 			case "notify.telegram":
 				tg.Send(msg.Payload)
 			}
 		}
 	}()
 
-	// 2. PGMQ Worker (The Docker Exec version)
-	go runPGMQPoller(tg)
-}
-
-func runPGMQPoller(tg *telegram.Service) {
-	log.Println("📥 [PGMQ] Worker started (Polling via Docker Exec)")
-
-	queues := []string{"ticket_insert_events", "ticket_status_reset_events"}
-	container := "supabase-db" // Adjust if your container name is different
-
-	for {
-		for _, q := range queues {
-			// 1. Read from Queue
-			// select msg_id, message->>'message' ...
-			query := fmt.Sprintf("select msg_id, message->>'message' from pgmq.read('%s', 30, 50);", q)
-			
-			cmd := exec.Command("docker", "exec", container, "psql", "-U", "supabase_admin", "-d", "postgres", "-Atc", query)
-			out, err := cmd.Output()
-			
-			if err != nil {
-				// Log verbose error only if it's NOT just an empty result or standard noise
-				// log.Printf("⚠️ [PGMQ] Read error: %v", err)
-				time.Sleep(1 * time.Second)
-				continue
-			}
-
-			outputStr := strings.TrimSpace(string(out))
-			if outputStr == "" {
-				continue
-			}
-
-			// 2. Process Lines
-			lines := strings.Split(outputStr, "\n")
-			for _, line := range lines {
-				if line == "" {
-					continue
-				}
-
-				// Split "123|Hello World" -> "123", "Hello World"
-				// strings.Cut splits on the *first* pipe, safe if message contains pipes
-				msgID, msgBody, found := strings.Cut(line, "|")
-				if !found {
-					continue
-				}
+	routing, routingErr := LoadRouting()
 
-				log.Printf("📩 [PGMQ] Processing MsgID: %s from Queue: %s", msgID, q)
+	// Every consumer keeps a connection busy in read_with_poll, so they get
+	// a pool of their own: one connection each plus one for archive and
+	// status writes. Monitors and jobs keep the shared db.Pool.
+	conns := 2
+	if routingErr == nil {
+		conns += len(routing.Queues)
+	}
+	pool, err := db.NewPool(ctx, db.URL(), int32(conns))
+	if err != nil {
+		log.Printf("❌ [PGMQ] %v", err)
+		return
+	}
 
-				// 3. Send to Telegram
-				tg.Send(msgBody)
+	// 2. Ops jobs requested from SQL (watchdog_jobs queue)
+	go runJobConsumer(ctx, tg, pool)
 
-				// 4. Delete Message
-				// select pgmq.delete('queue', id)
-				delQuery := fmt.Sprintf("select pgmq.delete('%s', %s);", q, msgID)
-				exec.Command("docker", "exec", container, "psql", "-U", "supabase_admin", "-d", "postgres", "-Atc", delQuery).Run()
-			}
-		}
+	// 3. PGMQ consumers (native connection, one per configured queue)
+	if routingErr != nil {
+		log.Printf("❌ [PGMQ] Invalid routing config: %v", routingErr)
+		tg.Send(fmt.Sprintf("⚠️ [PGMQ] Routing config invalid, queue consumers disabled: %v", routingErr))
+		return
+	}
+	if _, err := pool.Exec(ctx, `SELECT pgmq.create($1)`, routing.DeadLetterQueue); err != nil {
+		log.Printf("⚠️ [PGMQ] Could not create dead-letter queue %s: %v", routing.DeadLetterQueue, err)
+	}
 
-		// Sleep 1 second before next poll cycle (same as sleep 1 in shell script)
-		time.Sleep(1 * time.Second)
+	d := &dispatcher{tg: tg, rdb: rdb, http: &http.Client{Timeout: 15 * time.Second}}
+	for _, q := range routing.Queues {
+		go runPGMQConsumer(ctx, d, pool, q, routing.DeadLetterQueue)
 	}
-}
\ No newline at end of file
+}
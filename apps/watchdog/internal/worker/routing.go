@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"text/template"
+
+	"github.com/GoldenCarrotMLP/watchdog/internal/config"
+)
+
+// RoutingConfig is the format of pgmq.json: which queues to consume and where
+// each message goes. Adding a queue only needs a new entry here.
+type RoutingConfig struct {
+	DeadLetterQueue string       `json:"dead_letter_queue"`
+	MaxReads        int          `json:"max_reads"` // default for queues without their own
+	Queues          []QueueRoute `json:"queues"`
+}
+
+type QueueRoute struct {
+	Name     string         `json:"name"`
+	MaxReads int            `json:"max_reads,omitempty"`
+	Handlers []HandlerRoute `json:"handlers"`
+}
+
+// HandlerRoute configures one delivery target. Template is rendered over the
+// decoded JSON payload plus _queue, _msg_id and _read_ct.
+type HandlerRoute struct {
+	Type     string            `json:"type"` // telegram, webhook, email, redis
+	Template string            `json:"template,omitempty"`
+	URL      string            `json:"url,omitempty"`     // webhook
+	Headers  map[string]string `json:"headers,omitempty"` // webhook
+	To       []string          `json:"to,omitempty"`      // email
+	Subject  string            `json:"subject,omitempty"` // email, template
+	Channel  string            `json:"channel,omitempty"` // redis
+
+	tmpl    *template.Template
+	subject *template.Template
+}
+
+// The historical queues: payload {"message": "..."} forwarded to Telegram.
+var defaultRouting = RoutingConfig{
+	DeadLetterQueue: "watchdog_dead_letter",
+	MaxReads:        10,
+	Queues: []QueueRoute{
+		{Name: "ticket_insert_events", Handlers: []HandlerRoute{{Type: "telegram"}}},
+		{Name: "ticket_status_reset_events", Handlers: []HandlerRoute{{Type: "telegram"}}},
+	},
+}
+
+// default template: the "message" field when present, otherwise the whole payload.
+const defaultTemplate = `{{if .message}}{{.message}}{{else}}{{json .}}{{end}}`
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) string {
+		b, _ := json.MarshalIndent(stripMeta(v), "", "  ")
+		return string(b)
+	},
+	"default": func(def, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// LoadRouting reads PGMQ_ROUTING_FILE (default <config dir>/pgmq.json).
+// Fields the file leaves unset take the defaults.
+func LoadRouting() (RoutingConfig, error) {
+	// A fresh config: decoding over defaultRouting would merge file queues
+	// into the default ones and compile templates into the shared default.
+	var cfg RoutingConfig
+	if _, err := config.Load(config.Env("PGMQ_ROUTING_FILE", config.Path("pgmq.json")), &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.DeadLetterQueue == "" {
+		cfg.DeadLetterQueue = defaultRouting.DeadLetterQueue
+	}
+	if cfg.MaxReads <= 0 {
+		cfg.MaxReads = defaultRouting.MaxReads
+	}
+	if cfg.Queues == nil {
+		for _, q := range defaultRouting.Queues {
+			q.Handlers = slices.Clone(q.Handlers)
+			cfg.Queues = append(cfg.Queues, q)
+		}
+	}
+
+	for qi := range cfg.Queues {
+		q := &cfg.Queues[qi]
+		if q.MaxReads <= 0 {
+			q.MaxReads = cfg.MaxReads
+		}
+		if len(q.Handlers) == 0 {
+			return cfg, fmt.Errorf("queue %s has no handlers", q.Name)
+		}
+		for hi := range q.Handlers {
+			h := &q.Handlers[hi]
+			src := h.Template
+			if src == "" {
+				src = defaultTemplate
+			}
+			var err error
+			if h.tmpl, err = template.New(q.Name).Funcs(templateFuncs).Option("missingkey=zero").Parse(src); err != nil {
+				return cfg, fmt.Errorf("queue %s: %w", q.Name, err)
+			}
+			if h.Subject != "" {
+				if h.subject, err = template.New(q.Name + "-subject").Funcs(templateFuncs).Parse(h.Subject); err != nil {
+					return cfg, fmt.Errorf("queue %s subject: %w", q.Name, err)
+				}
+			}
+		}
+	}
+	return cfg, nil
+}
+
+func payloadData(queue string, m pgmqMessage) map[string]interface{} {
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(m.Payload, &data); err != nil {
+		// Scalar or array payloads are exposed as .payload
+		var v interface{}
+		json.Unmarshal(m.Payload, &v)
+		data = map[string]interface{}{"payload": v}
+	}
+	data["_queue"] = queue
+	data["_msg_id"] = m.ID
+	data["_read_ct"] = m.ReadCt
+	return data
+}
+
+func stripMeta(v interface{}) interface{} {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, val := range m {
+		if k == "_queue" || k == "_msg_id" || k == "_read_ct" {
+			continue
+		}
+		out[k] = val
+	}
+	return out
+}
+
+func render(t *template.Template, data map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -193,6 +193,16 @@ sed \
 
 echo "✅ $OUTPUT generated"
 
+# --- Docker secrets ---
+# Password of the least-privilege role the watchdog uses for pg_basebackup.
+# Keep an existing one so the role does not get rotated on every init.
+SECRETS_DIR="$SCRIPT_DIR/../secrets"
+mkdir -p "$SECRETS_DIR"
+if [ ! -s "$SECRETS_DIR/replication_password" ]; then
+  (umask 077 && gen_secret > "$SECRETS_DIR/replication_password")
+  echo "✅ secrets/replication_password generated"
+fi
+
 # --- Generate hosts.txt ---
 echo "Generating $HOSTS_OUTPUT for local development..."
 echo "# Copy the line below to your /etc/hosts (Linux/Mac) or C:\\Windows\\System32\\drivers\\etc\\hosts (Windows)" > "$HOSTS_OUTPUT"
@@ -2,6 +2,8 @@
 ALTER SYSTEM SET archive_mode = on;  
 ALTER SYSTEM SET archive_timeout = '60s';  
 ALTER SYSTEM SET archive_command = 'cp %p /var/lib/postgresql/data/wal_archive/%f';  
+-- WAL summaries are required for incremental base backups (PG17)
+ALTER SYSTEM SET summarize_wal = on;
   
 -- Reload configuration to apply changes without restart  
 SELECT pg_reload_conf();